@@ -9,13 +9,14 @@ import (
 
 	"database/sql"
 
+	"github.com/Dan9191/bank-service/internal/auth"
 	"github.com/Dan9191/bank-service/internal/config"
 	"github.com/Dan9191/bank-service/internal/handler"
 	"github.com/Dan9191/bank-service/internal/integrations/cbr"
 	"github.com/Dan9191/bank-service/internal/middleware"
+	"github.com/Dan9191/bank-service/internal/models"
 	"github.com/Dan9191/bank-service/internal/repository"
 	"github.com/Dan9191/bank-service/internal/service"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
@@ -52,10 +53,23 @@ func main() {
 		logger.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Load token signing keys
+	var keys *auth.KeySet
+	if cfg.JWTKeysDir != "" {
+		keys, err = auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+	} else {
+		logger.Warn("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key that is lost on restart")
+		keys, err = auth.NewEphemeralKeySet()
+	}
+	if err != nil {
+		logger.Fatalf("Failed to load token signing keys: %v", err)
+	}
+	tokenSigner := auth.NewTokenSigner(keys, cfg.JWTIssuer, cfg.JWTAudience)
+
 	// Initialize layers
 	repo := repository.NewRepository(db)
 	cbrClient := cbr.NewCBRClient(cfg, logger)
-	svc := service.NewService(repo, logger, cfg, cbrClient)
+	svc := service.NewService(repo, logger, cfg, cbrClient, tokenSigner)
 	h := handler.NewHandler(svc)
 
 	// Setup router
@@ -63,18 +77,15 @@ func main() {
 	// Public routes
 	r.HandleFunc("/register", h.Register).Methods("POST")
 	r.HandleFunc("/login", h.Login).Methods("POST")
-	// Test token endpoint
-	r.HandleFunc("/test-token", func(w http.ResponseWriter, r *http.Request) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-			Subject:   "1",
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		})
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
-		if err != nil {
-			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-			return
-		}
-		json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+	r.HandleFunc("/login/2fa", h.CompleteMFALogin).Methods("POST")
+	r.HandleFunc("/token/refresh", h.RefreshToken).Methods("POST")
+	r.HandleFunc("/verify-email", h.VerifyEmail).Methods("POST")
+	r.HandleFunc("/password/forgot", h.ForgotPassword).Methods("POST")
+	r.HandleFunc("/password/reset", h.ResetPassword).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(tokenSigner.JWKS())
 	}).Methods("GET")
 	// CBR key rate endpoint
 	r.HandleFunc("/key-rate", func(w http.ResponseWriter, r *http.Request) {
@@ -86,21 +97,80 @@ func main() {
 		}
 		json.NewEncoder(w).Encode(map[string]float64{"key_rate": rate})
 	}).Methods("GET")
+	// Card processing network routes
+	processingRouter := r.PathPrefix("/card-payments").Subrouter()
+	processingRouter.Use(middleware.ProcessingKeyMiddleware(cfg))
+	processingRouter.HandleFunc("/authorize", h.AuthorizeCardPayment).Methods("POST")
+	processingRouter.HandleFunc("/{id}/capture", h.CaptureCardPayment).Methods("POST")
+	processingRouter.HandleFunc("/{id}/reverse", h.ReverseCardPayment).Methods("POST")
 	// Protected routes
 	authRouter := r.PathPrefix("/").Subrouter()
-	authRouter.Use(middleware.AuthMiddleware(cfg))
-	authRouter.HandleFunc("/accounts", h.CreateAccount).Methods("POST")
-	authRouter.HandleFunc("/cards", h.CreateCard).Methods("POST")
-	authRouter.HandleFunc("/credits", h.CreateCredit).Methods("POST")
-	authRouter.HandleFunc("/credits/{id}/payments", h.ListPaymentSchedules).Methods("GET")
-	authRouter.HandleFunc("/analytics/income-expense", h.GetIncomeExpenseStats).Methods("GET")
-	authRouter.HandleFunc("/analytics/credit-burden", h.GetCreditBurden).Methods("GET")
-	authRouter.HandleFunc("/analytics/balance-forecast", h.ForecastBalance).Methods("GET")
-	authRouter.HandleFunc("/cards", h.ListCards).Methods("GET")
-	authRouter.HandleFunc("/transactions/deposit", h.Deposit).Methods("POST")
-	authRouter.HandleFunc("/transactions/withdraw", h.Withdraw).Methods("POST")
-	authRouter.HandleFunc("/transactions/transfer", h.Transfer).Methods("POST")
-	authRouter.HandleFunc("/transactions", h.ListTransactions).Methods("GET")
+	authRouter.Use(middleware.AuthMiddleware(tokenSigner, svc))
+	authRouter.HandleFunc("/logout", h.Logout).Methods("POST")
+	authRouter.HandleFunc("/logout-all", h.LogoutAll).Methods("POST")
+	authRouter.HandleFunc("/verify-email/resend", h.ResendEmailVerification).Methods("POST")
+	authRouter.HandleFunc("/password/change", h.ChangePassword).Methods("POST")
+	authRouter.HandleFunc("/2fa/totp/setup", h.SetupTOTP).Methods("POST")
+	authRouter.HandleFunc("/2fa/totp/enable", h.EnableTOTP).Methods("POST")
+	authRouter.HandleFunc("/2fa/totp/disable", h.DisableTOTP).Methods("POST")
+	authRouter.HandleFunc("/me", h.GetProfile).Methods("GET")
+	authRouter.HandleFunc("/me", h.UpdateProfile).Methods("PATCH")
+	authRouter.HandleFunc("/me/close", h.CloseUser).Methods("POST")
+	authRouter.HandleFunc("/sessions", h.ListSessions).Methods("GET")
+	authRouter.HandleFunc("/sessions/{id}", h.RevokeSession).Methods("DELETE")
+	authRouter.HandleFunc("/api-keys", h.CreateAPIKey).Methods("POST")
+	authRouter.HandleFunc("/api-keys", h.ListAPIKeys).Methods("GET")
+	authRouter.HandleFunc("/api-keys/{id}", h.RevokeAPIKey).Methods("DELETE")
+	authRouter.HandleFunc("/cards/{id}/reveal", h.RevealCard).Methods("POST")
+	authRouter.HandleFunc("/cards/{id}/pin", h.SetCardPIN).Methods("PUT")
+	// Routes also available to API keys with a matching scope
+	read := middleware.RequireScope(models.APIKeyScopeRead, models.APIKeyScopeWrite)
+	write := middleware.RequireScope(models.APIKeyScopeWrite)
+	transfers := middleware.RequireScope(models.APIKeyScopeTransfers, models.APIKeyScopeWrite)
+	authRouter.Handle("/accounts", write(http.HandlerFunc(h.CreateAccount))).Methods("POST")
+	authRouter.Handle("/accounts", read(http.HandlerFunc(h.ListAccounts))).Methods("GET")
+	authRouter.Handle("/accounts/{id}", read(http.HandlerFunc(h.GetAccount))).Methods("GET")
+	authRouter.Handle("/accounts/{id}/balance", read(http.HandlerFunc(h.GetAccountBalance))).Methods("GET")
+	authRouter.HandleFunc("/accounts/{id}/close", h.CloseAccount).Methods("POST")
+	authRouter.Handle("/cards", write(http.HandlerFunc(h.CreateCard))).Methods("POST")
+	authRouter.Handle("/credits", write(http.HandlerFunc(h.CreateCredit))).Methods("POST")
+	authRouter.Handle("/credits/{id}/payments", read(http.HandlerFunc(h.ListPaymentSchedules))).Methods("GET")
+	authRouter.Handle("/analytics/income-expense", read(http.HandlerFunc(h.GetIncomeExpenseStats))).Methods("GET")
+	authRouter.Handle("/analytics/credit-burden", read(http.HandlerFunc(h.GetCreditBurden))).Methods("GET")
+	authRouter.Handle("/analytics/balance-forecast", read(http.HandlerFunc(h.ForecastBalance))).Methods("GET")
+	authRouter.Handle("/cards", read(http.HandlerFunc(h.ListCards))).Methods("GET")
+	authRouter.Handle("/cards/{id}", write(http.HandlerFunc(h.UpdateCardStatus))).Methods("PATCH")
+	authRouter.Handle("/cards/{id}/reissue", write(http.HandlerFunc(h.ReissueCard))).Methods("POST")
+	authRouter.Handle("/cards/{id}/limits", read(http.HandlerFunc(h.GetCardLimits))).Methods("GET")
+	authRouter.Handle("/cards/{id}/limits", write(http.HandlerFunc(h.SetCardLimits))).Methods("PUT")
+	authRouter.Handle("/transactions/deposit", write(http.HandlerFunc(h.Deposit))).Methods("POST")
+	authRouter.Handle("/transactions/withdraw", write(http.HandlerFunc(h.Withdraw))).Methods("POST")
+	authRouter.Handle("/transactions/transfer", transfers(http.HandlerFunc(h.Transfer))).Methods("POST")
+	authRouter.Handle("/transactions/transfer/preview", transfers(http.HandlerFunc(h.PreviewTransferRecipient))).Methods("POST")
+	authRouter.Handle("/transactions", read(http.HandlerFunc(h.ListTransactions))).Methods("GET")
+	authRouter.Handle("/holds", write(http.HandlerFunc(h.CreateHold))).Methods("POST")
+	authRouter.Handle("/holds", read(http.HandlerFunc(h.ListHolds))).Methods("GET")
+	authRouter.Handle("/holds/{id}/capture", write(http.HandlerFunc(h.CaptureHold))).Methods("POST")
+	authRouter.Handle("/holds/{id}/release", write(http.HandlerFunc(h.ReleaseHold))).Methods("POST")
+	// Staff routes
+	adminRouter := authRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(middleware.RequireRole(models.RoleSupport, models.RoleOperator, models.RoleAdmin))
+	operatorOnly := middleware.RequireRole(models.RoleOperator, models.RoleAdmin)
+	adminOnly := middleware.RequireRole(models.RoleAdmin)
+	adminRouter.HandleFunc("/users", h.SearchUsers).Methods("GET")
+	adminRouter.HandleFunc("/users/{id}", h.AdminGetUser).Methods("GET")
+	adminRouter.Handle("/users/{id}/freeze", operatorOnly(http.HandlerFunc(h.FreezeUser))).Methods("POST")
+	adminRouter.Handle("/users/{id}/unfreeze", operatorOnly(http.HandlerFunc(h.UnfreezeUser))).Methods("POST")
+	adminRouter.Handle("/users/{id}/unlock", operatorOnly(http.HandlerFunc(h.UnlockUser))).Methods("POST")
+	adminRouter.Handle("/users/{id}/role", adminOnly(http.HandlerFunc(h.SetUserRole))).Methods("PUT")
+	adminRouter.HandleFunc("/accounts/{id}", h.AdminGetAccount).Methods("GET")
+	adminRouter.Handle("/accounts/{id}/freeze", operatorOnly(http.HandlerFunc(h.FreezeAccount))).Methods("POST")
+	adminRouter.Handle("/accounts/{id}/unfreeze", operatorOnly(http.HandlerFunc(h.UnfreezeAccount))).Methods("POST")
+	adminRouter.HandleFunc("/credits/{id}", h.AdminGetCredit).Methods("GET")
+	adminRouter.HandleFunc("/cards/{id}", h.AdminGetCard).Methods("GET")
+	adminRouter.Handle("/transactions/{id}/reverse", adminOnly(http.HandlerFunc(h.ReverseTransaction))).Methods("POST")
+	adminRouter.Handle("/card-reencryption", adminOnly(http.HandlerFunc(h.StartCardReencryption))).Methods("POST")
+	adminRouter.Handle("/card-reencryption/{id}", adminOnly(http.HandlerFunc(h.GetCardReencryption))).Methods("GET")
 
 	// Start server
 	addr := fmt.Sprintf(":%s", cfg.Port)
@@ -215,6 +285,441 @@ func runMigrations(db *sql.DB, logger *logrus.Logger) error {
 		return fmt.Errorf("failed to create bank.payment_schedules table: %w", err)
 	}
 
+	logger.Debug("Adding role to bank.users")
+	_, err = db.Exec(`ALTER TABLE bank.users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'customer'`)
+	if err != nil {
+		return fmt.Errorf("failed to add role to bank.users: %w", err)
+	}
+
+	logger.Debug("Adding reversal references to bank.transactions")
+	_, err = db.Exec(`
+		ALTER TABLE bank.transactions
+			ADD COLUMN IF NOT EXISTS related_id BIGINT REFERENCES bank.transactions(id),
+			ADD COLUMN IF NOT EXISTS reversal_of BIGINT REFERENCES bank.transactions(id)`)
+	if err != nil {
+		return fmt.Errorf("failed to add reversal references to bank.transactions: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS transactions_reversal_of_idx
+		ON bank.transactions (reversal_of)
+		WHERE reversal_of IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to create transactions_reversal_of_idx: %w", err)
+	}
+
+	logger.Debug("Creating table bank.holds")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.holds (
+			id BIGSERIAL PRIMARY KEY,
+			account_id BIGINT REFERENCES bank.accounts(id) ON DELETE CASCADE,
+			amount NUMERIC(15, 2) NOT NULL,
+			captured_amount NUMERIC(15, 2) DEFAULT 0.0,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			description TEXT,
+			transaction_id BIGINT REFERENCES bank.transactions(id),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.holds table: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS holds_account_pending_idx
+		ON bank.holds (account_id)
+		WHERE status = 'pending'`)
+	if err != nil {
+		return fmt.Errorf("failed to create holds_account_pending_idx: %w", err)
+	}
+
+	logger.Debug("Creating table bank.card_authorizations")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.card_authorizations (
+			id BIGSERIAL PRIMARY KEY,
+			card_id BIGINT REFERENCES bank.cards(id) ON DELETE CASCADE,
+			amount NUMERIC(15, 2) NOT NULL,
+			currency VARCHAR(3) NOT NULL,
+			merchant_id VARCHAR(50),
+			merchant_name VARCHAR(255),
+			mcc VARCHAR(4),
+			response_code VARCHAR(2) NOT NULL,
+			auth_code VARCHAR(6),
+			transaction_id BIGINT REFERENCES bank.transactions(id),
+			hold_id BIGINT REFERENCES bank.holds(id),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.card_authorizations table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS cards_hmac_idx ON bank.cards (hmac)`)
+	if err != nil {
+		return fmt.Errorf("failed to create cards_hmac_idx: %w", err)
+	}
+
+	logger.Debug("Adding lifecycle columns to bank.cards")
+	_, err = db.Exec(`
+		ALTER TABLE bank.cards
+			ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active',
+			ADD COLUMN IF NOT EXISTS expires_on DATE,
+			ADD COLUMN IF NOT EXISTS reissued_from BIGINT REFERENCES bank.cards(id)`)
+	if err != nil {
+		return fmt.Errorf("failed to add lifecycle columns to bank.cards: %w", err)
+	}
+
+	logger.Debug("Adding payment system and PAN fingerprint to bank.cards")
+	_, err = db.Exec(`
+		ALTER TABLE bank.cards
+			ADD COLUMN IF NOT EXISTS payment_system VARCHAR(20) NOT NULL DEFAULT 'visa',
+			ADD COLUMN IF NOT EXISTS pan_fingerprint TEXT`)
+	if err != nil {
+		return fmt.Errorf("failed to add payment system and PAN fingerprint to bank.cards: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS cards_pan_fingerprint_idx
+		ON bank.cards (pan_fingerprint)
+		WHERE pan_fingerprint IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to create cards_pan_fingerprint_idx: %w", err)
+	}
+
+	logger.Debug("Creating table bank.reencryption_jobs")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.reencryption_jobs (
+			id BIGSERIAL PRIMARY KEY,
+			key_version INTEGER NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'running',
+			processed INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			started_by BIGINT REFERENCES bank.users(id),
+			started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP WITH TIME ZONE
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.reencryption_jobs table: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS reencryption_jobs_running_idx
+		ON bank.reencryption_jobs ((status))
+		WHERE status = 'running'`)
+	if err != nil {
+		return fmt.Errorf("failed to create reencryption_jobs_running_idx: %w", err)
+	}
+
+	logger.Debug("Adding masked PAN to bank.cards")
+	_, err = db.Exec(`ALTER TABLE bank.cards ADD COLUMN IF NOT EXISTS masked_pan VARCHAR(19)`)
+	if err != nil {
+		return fmt.Errorf("failed to add masked PAN to bank.cards: %w", err)
+	}
+
+	logger.Debug("Creating table bank.card_reveals")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.card_reveals (
+			id BIGSERIAL PRIMARY KEY,
+			card_id BIGINT REFERENCES bank.cards(id) ON DELETE CASCADE,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			ip_address VARCHAR(45),
+			user_agent TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.card_reveals table: %w", err)
+	}
+
+	logger.Debug("Creating table bank.card_limits")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.card_limits (
+			card_id BIGINT PRIMARY KEY REFERENCES bank.cards(id) ON DELETE CASCADE,
+			daily_limit NUMERIC(15, 2),
+			monthly_limit NUMERIC(15, 2),
+			per_transaction_limit NUMERIC(15, 2),
+			online_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			atm_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			contactless_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			blocked_mccs TEXT[] NOT NULL DEFAULT '{}',
+			daily_spent NUMERIC(15, 2) NOT NULL DEFAULT 0,
+			monthly_spent NUMERIC(15, 2) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.card_limits table: %w", err)
+	}
+	_, err = db.Exec(`ALTER TABLE bank.card_authorizations ADD COLUMN IF NOT EXISTS channel VARCHAR(20)`)
+	if err != nil {
+		return fmt.Errorf("failed to add channel to bank.card_authorizations: %w", err)
+	}
+
+	logger.Debug("Adding card type columns to bank.cards")
+	_, err = db.Exec(`
+		ALTER TABLE bank.cards
+			ADD COLUMN IF NOT EXISTS card_type VARCHAR(20) NOT NULL DEFAULT 'physical',
+			ADD COLUMN IF NOT EXISTS spend_cap NUMERIC(15, 2),
+			ADD COLUMN IF NOT EXISTS total_spent NUMERIC(15, 2) NOT NULL DEFAULT 0`)
+	if err != nil {
+		return fmt.Errorf("failed to add card type columns to bank.cards: %w", err)
+	}
+
+	logger.Debug("Adding PIN columns to bank.cards")
+	_, err = db.Exec(`
+		ALTER TABLE bank.cards
+			ADD COLUMN IF NOT EXISTS pin_hash TEXT,
+			ADD COLUMN IF NOT EXISTS pin_attempts INT NOT NULL DEFAULT 0`)
+	if err != nil {
+		return fmt.Errorf("failed to add PIN columns to bank.cards: %w", err)
+	}
+
+	logger.Debug("Creating table bank.refresh_tokens")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.refresh_tokens (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			family_id VARCHAR(64) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			access_jti VARCHAR(64) NOT NULL,
+			access_expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.refresh_tokens table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON bank.refresh_tokens (family_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh_tokens_family_idx: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS refresh_tokens_access_jti_idx ON bank.refresh_tokens (access_jti)`)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh_tokens_access_jti_idx: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON bank.refresh_tokens (user_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh_tokens_user_idx: %w", err)
+	}
+
+	logger.Debug("Creating table bank.revoked_tokens")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.revoked_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.revoked_tokens table: %w", err)
+	}
+
+	// Users registered before email verification are treated as verified
+	logger.Debug("Adding email verification columns to bank.users")
+	_, err = db.Exec(`
+		ALTER TABLE bank.users
+			ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS email_verification_sent_at TIMESTAMP WITH TIME ZONE`)
+	if err != nil {
+		return fmt.Errorf("failed to add email verification columns to bank.users: %w", err)
+	}
+	_, err = db.Exec(`ALTER TABLE bank.users ALTER COLUMN email_verified_at DROP DEFAULT`)
+	if err != nil {
+		return fmt.Errorf("failed to drop email_verified_at default: %w", err)
+	}
+
+	logger.Debug("Creating table bank.password_reset_tokens")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.password_reset_tokens (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.password_reset_tokens table: %w", err)
+	}
+
+	logger.Debug("Adding two-factor authentication columns to bank.users")
+	_, err = db.Exec(`
+		ALTER TABLE bank.users
+			ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+			ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP WITH TIME ZONE,
+			ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0`)
+	if err != nil {
+		return fmt.Errorf("failed to add two-factor authentication columns to bank.users: %w", err)
+	}
+	_, err = db.Exec(`ALTER TABLE bank.refresh_tokens ADD COLUMN IF NOT EXISTS amr TEXT NOT NULL DEFAULT 'pwd'`)
+	if err != nil {
+		return fmt.Errorf("failed to add amr to bank.refresh_tokens: %w", err)
+	}
+
+	logger.Debug("Creating table bank.recovery_codes")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.recovery_codes (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.recovery_codes table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON bank.recovery_codes (user_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create recovery_codes_user_idx: %w", err)
+	}
+
+	logger.Debug("Creating table bank.login_attempts")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.login_attempts (
+			scope VARCHAR(10) NOT NULL,
+			key VARCHAR(255) NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			blocked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+			last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			PRIMARY KEY (scope, key)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.login_attempts table: %w", err)
+	}
+
+	logger.Debug("Adding frozen_at to bank.users")
+	_, err = db.Exec(`ALTER TABLE bank.users ADD COLUMN IF NOT EXISTS frozen_at TIMESTAMP WITH TIME ZONE`)
+	if err != nil {
+		return fmt.Errorf("failed to add frozen_at to bank.users: %w", err)
+	}
+
+	logger.Debug("Creating table bank.api_keys")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.api_keys (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes TEXT NOT NULL,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.api_keys table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS api_keys_user_idx ON bank.api_keys (user_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create api_keys_user_idx: %w", err)
+	}
+
+	logger.Debug("Creating table bank.sessions")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.sessions (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			family_id VARCHAR(64) UNIQUE NOT NULL,
+			ip_address VARCHAR(45),
+			user_agent TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.sessions table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS sessions_user_idx ON bank.sessions (user_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions_user_idx: %w", err)
+	}
+
+	logger.Debug("Adding profile and closure columns to bank.users")
+	_, err = db.Exec(`
+		ALTER TABLE bank.users
+			ADD COLUMN IF NOT EXISTS full_name VARCHAR(200),
+			ADD COLUMN IF NOT EXISTS phone VARCHAR(16),
+			ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP WITH TIME ZONE`)
+	if err != nil {
+		return fmt.Errorf("failed to add profile columns to bank.users: %w", err)
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS users_phone_idx ON bank.users (phone) WHERE phone IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to create users_phone_idx: %w", err)
+	}
+
+	logger.Debug("Adding status column to bank.accounts")
+	_, err = db.Exec(`ALTER TABLE bank.accounts ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'`)
+	if err != nil {
+		return fmt.Errorf("failed to add status column to bank.accounts: %w", err)
+	}
+
+	logger.Debug("Adding number column to bank.accounts")
+	_, err = db.Exec(`ALTER TABLE bank.accounts ADD COLUMN IF NOT EXISTS number VARCHAR(20)`)
+	if err != nil {
+		return fmt.Errorf("failed to add number column to bank.accounts: %w", err)
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS accounts_number_idx ON bank.accounts (number)`)
+	if err != nil {
+		return fmt.Errorf("failed to create accounts_number_idx: %w", err)
+	}
+
+	logger.Debug("Adding origin column to bank.holds")
+	_, err = db.Exec(`ALTER TABLE bank.holds ADD COLUMN IF NOT EXISTS origin VARCHAR(20) NOT NULL DEFAULT 'customer'`)
+	if err != nil {
+		return fmt.Errorf("failed to add origin column to bank.holds: %w", err)
+	}
+	_, err = db.Exec(`
+		UPDATE bank.holds
+		SET origin = 'card_network'
+		WHERE origin = 'customer'
+		AND id IN (SELECT hold_id FROM bank.card_authorizations WHERE hold_id IS NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill origin of card authorization holds: %w", err)
+	}
+
+	logger.Debug("Adding reversed_at column to bank.card_authorizations")
+	_, err = db.Exec(`ALTER TABLE bank.card_authorizations ADD COLUMN IF NOT EXISTS reversed_at TIMESTAMP WITH TIME ZONE`)
+	if err != nil {
+		return fmt.Errorf("failed to add reversed_at column to bank.card_authorizations: %w", err)
+	}
+
+	logger.Debug("Adding card_id column to bank.holds")
+	_, err = db.Exec(`ALTER TABLE bank.holds ADD COLUMN IF NOT EXISTS card_id BIGINT REFERENCES bank.cards(id)`)
+	if err != nil {
+		return fmt.Errorf("failed to add card_id column to bank.holds: %w", err)
+	}
+	_, err = db.Exec(`
+		UPDATE bank.holds h
+		SET card_id = a.card_id
+		FROM bank.card_authorizations a
+		WHERE a.hold_id = h.id
+		AND h.card_id IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill card_id of card authorization holds: %w", err)
+	}
+
+	logger.Debug("Adding pending_email column to bank.users")
+	_, err = db.Exec(`ALTER TABLE bank.users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255)`)
+	if err != nil {
+		return fmt.Errorf("failed to add pending_email column to bank.users: %w", err)
+	}
+
+	logger.Debug("Creating table bank.recipient_lookups")
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank.recipient_lookups (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT REFERENCES bank.users(id) ON DELETE CASCADE,
+			recipient_type VARCHAR(20) NOT NULL,
+			account_id BIGINT REFERENCES bank.accounts(id),
+			ip_address VARCHAR(45),
+			user_agent TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create bank.recipient_lookups table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS recipient_lookups_user_idx ON bank.recipient_lookups (user_id, created_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create recipient_lookups_user_idx: %w", err)
+	}
+
 	logger.Info("Database migrations completed successfully")
 	return nil
 }
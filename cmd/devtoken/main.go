@@ -0,0 +1,66 @@
+// Command devtoken issues access tokens for local testing. It signs tokens with the active
+// key in JWT_KEYS_DIR and only runs when APP_ENV is explicitly set to development or test.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Dan9191/bank-service/internal/auth"
+	"github.com/Dan9191/bank-service/internal/config"
+	"github.com/Dan9191/bank-service/internal/models"
+	"github.com/Dan9191/bank-service/internal/utils"
+)
+
+func main() {
+	userID := flag.Int64("user", 0, "ID of the user to issue the token for")
+	role := flag.String("role", models.RoleCustomer, "role carried by the token")
+	ttl := flag.Duration("ttl", 0, "token lifetime (defaults to ACCESS_TOKEN_TTL)")
+	flag.Parse()
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	// APP_ENV defaults to development, so only an explicit setting enables token issuing
+	if _, exists := os.LookupEnv("APP_ENV"); !exists || (cfg.AppEnv != config.EnvDevelopment && cfg.AppEnv != config.EnvTest) {
+		fmt.Fprintf(os.Stderr, "Refusing to issue development tokens unless APP_ENV is %s or %s\n", config.EnvDevelopment, config.EnvTest)
+		os.Exit(1)
+	}
+	if *userID <= 0 {
+		fmt.Fprintln(os.Stderr, "-user must be a positive user ID")
+		os.Exit(2)
+	}
+	if !models.ValidRole(*role) {
+		fmt.Fprintf(os.Stderr, "Unknown role: %s\n", *role)
+		os.Exit(2)
+	}
+	if cfg.JWTKeysDir == "" {
+		fmt.Fprintln(os.Stderr, "JWT_KEYS_DIR must be set to issue tokens the API accepts")
+		os.Exit(1)
+	}
+	keys, err := auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load signing keys: %v\n", err)
+		os.Exit(1)
+	}
+	signer := auth.NewTokenSigner(keys, cfg.JWTIssuer, cfg.JWTAudience)
+	if *ttl <= 0 {
+		*ttl = cfg.AccessTokenTTL
+	}
+
+	jti, err := utils.GenerateToken(16)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate token ID: %v\n", err)
+		os.Exit(1)
+	}
+	token, err := signer.NewAccessToken(*userID, *role, []string{models.AuthMethodPassword}, "dev-"+jti, time.Now(), *ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to issue token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is a token signing key identified by its kid. Retired keys have no private
+// key and are kept only to verify tokens issued before a rotation.
+type signingKey struct {
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet holds the asymmetric keys tokens are signed and verified with
+type KeySet struct {
+	keys      map[string]*signingKey
+	activeKID string
+}
+
+// LoadKeySet loads PEM encoded RSA (RS256) and Ed25519 (EdDSA) keys from dir. Each file
+// "<kid>.pem" holds a PKCS#8 or PKCS#1 private key, or a PKIX public key for a retired key
+// that may still verify tokens. activeKID names the key new tokens are signed with and may
+// be empty when dir holds a single private key.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	ks := &KeySet{keys: make(map[string]*signingKey)}
+	var privateKIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		kid := strings.TrimSuffix(entry.Name(), ".pem")
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", kid, err)
+		}
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", kid, err)
+		}
+		ks.keys[kid] = key
+		if key.private != nil {
+			privateKIDs = append(privateKIDs, kid)
+		}
+	}
+
+	if activeKID == "" && len(privateKIDs) == 1 {
+		activeKID = privateKIDs[0]
+	}
+	key, ok := ks.keys[activeKID]
+	if !ok || key.private == nil {
+		return nil, fmt.Errorf("active key %q has no private key in %s", activeKID, dir)
+	}
+	ks.activeKID = activeKID
+	return ks, nil
+}
+
+// NewEphemeralKeySet generates a single Ed25519 key that lives only as long as the process
+func NewEphemeralKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	kid := fmt.Sprintf("ephemeral-%x", private.Public().(ed25519.PublicKey)[:4])
+	return &KeySet{
+		keys: map[string]*signingKey{
+			kid: {method: jwt.SigningMethodEdDSA, private: private, public: private.Public()},
+		},
+		activeKID: kid,
+	}, nil
+}
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing or verifying tokens
+const minRSAKeyBits = 2048
+
+// parseSigningKey parses a PEM encoded private or public key
+func parseSigningKey(data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		return &signingKey{method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		// Retired keys still verify tokens, so they are held to the same minimum size
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		return &signingKey{method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &signingKey{method: jwt.SigningMethodEdDSA, public: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, including retired keys still accepted for verification
+func (ks *KeySet) JWKS() *JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := &JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		jwk := JWK{KeyID: kid, Use: "sig", Algorithm: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/Dan9191/bank-service/internal/models"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID      int64
+	Roles       []string
+	TokenID     string   // jti of the access token
+	AuthMethods []string // amr of the access token
+	TwoFactor   bool     // Whether the session was established with a second factor
+	APIKeyID    int64    // Set when authenticated with an API key
+	Scopes      []string // Scopes of the API key
+}
+
+// principalKey is the context key of the request principal
+type principalKey struct{}
+
+// NewPrincipal builds the principal of a verified access token
+func NewPrincipal(claims *Claims) (*Principal, error) {
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token subject: %w", err)
+	}
+	principal := &Principal{
+		UserID:      userID,
+		TokenID:     claims.ID,
+		AuthMethods: claims.AMR,
+		TwoFactor:   slices.Contains(claims.AMR, models.AuthMethodOTP),
+	}
+	if claims.Role != "" {
+		principal.Roles = []string{claims.Role}
+	}
+	return principal, nil
+}
+
+// HasRole reports whether the principal has any of the given roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the principal may use a route requiring any of the given scopes.
+// Only API keys are limited by scopes.
+func (p *Principal) HasScope(scopes ...string) bool {
+	if p.APIKeyID == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		if slices.Contains(p.Scopes, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of an authenticated request
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// UserIDFromContext returns the user ID of the principal of an authenticated request
+func UserIDFromContext(ctx context.Context) (int64, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return principal.UserID, true
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims carried by access tokens
+type Claims struct {
+	Role string   `json:"role,omitempty"`
+	AMR  []string `json:"amr,omitempty"` // Authentication methods used to log in
+	jwt.RegisteredClaims
+}
+
+// TokenSigner signs and verifies access tokens with a key set, binding them to an issuer and audience
+type TokenSigner struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+}
+
+// NewTokenSigner creates a token signer
+func NewTokenSigner(keys *KeySet, issuer, audience string) *TokenSigner {
+	return &TokenSigner{keys: keys, issuer: issuer, audience: audience}
+}
+
+// JWKS returns the public keys tokens may be verified with
+func (s *TokenSigner) JWKS() *JWKS {
+	return s.keys.JWKS()
+}
+
+// NewAccessToken signs an access token identified by jti for a user with the given role and
+// authentication methods
+func (s *TokenSigner) NewAccessToken(userID int64, role string, amr []string, jti string, issuedAt time.Time, ttl time.Duration) (string, error) {
+	key := s.keys.keys[s.keys.activeKID]
+	token := jwt.NewWithClaims(key.method, Claims{
+		Role: role,
+		AMR:  amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.issuer,
+			Audience:  jwt.ClaimStrings{s.audience},
+			Subject:   fmt.Sprintf("%d", userID),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ttl)),
+		},
+	})
+	token.Header["kid"] = s.keys.activeKID
+	tokenString, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// ParseAccessToken verifies the signature, key ID, issuer, audience and expiry of an
+// access token and returns its claims
+func (s *TokenSigner) ParseAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+		}
+		return key.public, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(s.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if claims.ID == "" || claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
@@ -1,48 +1,225 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BINRange is an inclusive range of card number prefixes of equal length
+type BINRange struct {
+	Low  string
+	High string
+}
+
+// Deployment environments
+const (
+	EnvDevelopment = "development"
+	EnvTest        = "test"
+	EnvProduction  = "production"
 )
 
 // Config holds application configuration
 type Config struct {
-	Port          string
-	DBConn        string
-	LogLevel      string
-	JWTSecret     string
-	CBRURL        string
-	HMACSecret    string
-	EncryptionKey string
-	SMTPHost      string
-	SMTPPort      string
-	SMTPUsername  string
-	SMTPPassword  string
-	SenderEmail   string
+	AppEnv               string
+	Port                 string
+	DBConn               string
+	LogLevel             string
+	JWTKeysDir           string // Directory of PEM signing keys named <kid>.pem
+	JWTActiveKeyID       string
+	JWTIssuer            string
+	JWTAudience          string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	CBRURL               string
+	HMACSecret           string
+	EncryptionKey        string         // Legacy AES-CBC key, used only to decrypt old values
+	EncryptionKeys       map[int][]byte // AES-GCM keyring indexed by key version
+	EncryptionKeyVersion int            // Version of the active key; other versions are retired
+	SMTPHost             string
+	SMTPPort             string
+	SMTPUsername         string
+	SMTPPassword         string
+	SenderEmail          string
+	HoldTTL              time.Duration
+	ProcessingAPIKey     string
+	CardBINRanges        map[string][]BINRange // Keyed by payment system
+	DefaultPaymentSystem string
+	PANFingerprintKey    string
+	EmailTokenKey        string // Signs stateless email verification tokens
+	EmailVerificationURL string
+	EmailVerificationTTL time.Duration
+	PasswordResetURL     string
+	PasswordResetTTL     time.Duration
+	PasswordMinLength    int
+	BreachedPasswordFile string // One breached password per line; optional
+	TOTPIssuer           string
+	MFATokenKey          string  // Signs the token linking the two login steps
+	StepUpTransferLimit  float64 // Transfers above this amount require step-up verification
+	LoginMaxFailures     int     // Failures per email before it is locked out
+	LoginIPMaxFailures   int     // Failures per client IP before it is locked out
+	LoginBackoffBase     time.Duration
+	LoginLockoutDuration time.Duration
+	CardPINMaxAttempts   int
+	RecipientLookupLimit int // Transfer recipient previews per user within RecipientLookupSpan
+	RecipientLookupSpan  time.Duration
+	BankBIC              string // Control keys of account numbers are computed against it
+	AccountBalanceNumber string // Balance account of new customer accounts
+	AccountBranchCode    string
 }
 
 // NewConfig loads configuration from environment variables
 func NewConfig() (*Config, error) {
 	cfg := &Config{
-		Port:          getEnv("PORT", "8080"),
-		DBConn:        getEnv("DB_CONN", "host=localhost port=5436 user=test password=test dbname=bank sslmode=disable"),
-		LogLevel:      getEnv("LOG_LEVEL", "INFO"),
-		JWTSecret:     getEnv("JWT_SECRET", "secret"),
-		CBRURL:        getEnv("CBR_URL", "https://www.cbr.ru/DailyInfoWebServ/DailyInfo.asmx"),
-		HMACSecret:    getEnv("HMAC_SECRET", "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6"),
-		EncryptionKey: getEnv("ENCRYPTION_KEY", "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6"),
-		SMTPHost:      getEnv("SMTP_HOST", "smtp.gmail.com"),
-		SMTPPort:      getEnv("SMTP_PORT", "587"),
-		SMTPUsername:  getEnv("SMTP_USERNAME", "-"),
-		SMTPPassword:  getEnv("SMTP_PASSWORD", "-"),
-		SenderEmail:   getEnv("SENDER_EMAIL", "-"),
+		AppEnv:               getEnv("APP_ENV", EnvDevelopment),
+		Port:                 getEnv("PORT", "8080"),
+		DBConn:               getEnv("DB_CONN", "host=localhost port=5436 user=test password=test dbname=bank sslmode=disable"),
+		LogLevel:             getEnv("LOG_LEVEL", "INFO"),
+		JWTKeysDir:           getEnv("JWT_KEYS_DIR", ""),
+		JWTActiveKeyID:       getEnv("JWT_ACTIVE_KID", ""),
+		JWTIssuer:            getEnv("JWT_ISSUER", "bank-service"),
+		JWTAudience:          getEnv("JWT_AUDIENCE", "bank-api"),
+		CBRURL:               getEnv("CBR_URL", "https://www.cbr.ru/DailyInfoWebServ/DailyInfo.asmx"),
+		HMACSecret:           getEnv("HMAC_SECRET", "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6"),
+		EncryptionKey:        getEnv("ENCRYPTION_KEY", "a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6"),
+		SMTPHost:             getEnv("SMTP_HOST", "smtp.gmail.com"),
+		SMTPPort:             getEnv("SMTP_PORT", "587"),
+		SMTPUsername:         getEnv("SMTP_USERNAME", "-"),
+		SMTPPassword:         getEnv("SMTP_PASSWORD", "-"),
+		SenderEmail:          getEnv("SENDER_EMAIL", "-"),
+		ProcessingAPIKey:     getEnv("PROCESSING_API_KEY", ""),
+		DefaultPaymentSystem: getEnv("CARD_DEFAULT_PAYMENT_SYSTEM", "visa"),
+		EmailTokenKey:        getEnv("EMAIL_TOKEN_KEY", "c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1"),
+		EmailVerificationURL: getEnv("EMAIL_VERIFICATION_URL", "http://localhost:8080/verify-email"),
+		PasswordResetURL:     getEnv("PASSWORD_RESET_URL", "http://localhost:8080/password/reset"),
+		BreachedPasswordFile: getEnv("BREACHED_PASSWORD_FILE", ""),
+		TOTPIssuer:           getEnv("TOTP_ISSUER", "Bank Service"),
+		MFATokenKey:          getEnv("MFA_TOKEN_KEY", "d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2"),
+		PANFingerprintKey:    getEnv("PAN_FINGERPRINT_KEY", "f1e2d3c4b5a6f7e8d9c0b1a2f3e4d5c6f1e2d3c4b5a6f7e8d9c0b1a2f3e4d5c6"),
+		BankBIC:              getEnv("BANK_BIC", "044525999"),
+		AccountBalanceNumber: getEnv("ACCOUNT_BALANCE_NUMBER", "40817"),
+		AccountBranchCode:    getEnv("ACCOUNT_BRANCH_CODE", "0000"),
+	}
+
+	switch cfg.AppEnv {
+	case EnvDevelopment, EnvTest, EnvProduction:
+	default:
+		return nil, fmt.Errorf("APP_ENV must be %s, %s or %s", EnvDevelopment, EnvTest, EnvProduction)
+	}
+
+	accessTokenTTL, err := time.ParseDuration(getEnv("ACCESS_TOKEN_TTL", "15m"))
+	if err != nil || accessTokenTTL <= 0 {
+		return nil, fmt.Errorf("ACCESS_TOKEN_TTL must be a positive duration")
+	}
+	cfg.AccessTokenTTL = accessTokenTTL
+	refreshTokenTTL, err := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "720h"))
+	if err != nil || refreshTokenTTL <= 0 {
+		return nil, fmt.Errorf("REFRESH_TOKEN_TTL must be a positive duration")
+	}
+	cfg.RefreshTokenTTL = refreshTokenTTL
+
+	emailVerificationTTL, err := time.ParseDuration(getEnv("EMAIL_VERIFICATION_TTL", "24h"))
+	if err != nil || emailVerificationTTL <= 0 {
+		return nil, fmt.Errorf("EMAIL_VERIFICATION_TTL must be a positive duration")
+	}
+	cfg.EmailVerificationTTL = emailVerificationTTL
+
+	passwordResetTTL, err := time.ParseDuration(getEnv("PASSWORD_RESET_TTL", "1h"))
+	if err != nil || passwordResetTTL <= 0 {
+		return nil, fmt.Errorf("PASSWORD_RESET_TTL must be a positive duration")
+	}
+	cfg.PasswordResetTTL = passwordResetTTL
+	cfg.PasswordMinLength, err = strconv.Atoi(getEnv("PASSWORD_MIN_LENGTH", "10"))
+	if err != nil || cfg.PasswordMinLength <= 0 {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH must be a positive integer")
+	}
+
+	cfg.StepUpTransferLimit, err = strconv.ParseFloat(getEnv("STEP_UP_TRANSFER_LIMIT", "100000"), 64)
+	if err != nil || cfg.StepUpTransferLimit < 0 {
+		return nil, fmt.Errorf("STEP_UP_TRANSFER_LIMIT must be a non-negative amount")
+	}
+
+	cfg.LoginMaxFailures, err = strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5"))
+	if err != nil || cfg.LoginMaxFailures <= 0 {
+		return nil, fmt.Errorf("LOGIN_MAX_FAILURES must be a positive integer")
+	}
+	cfg.LoginIPMaxFailures, err = strconv.Atoi(getEnv("LOGIN_IP_MAX_FAILURES", "50"))
+	if err != nil || cfg.LoginIPMaxFailures <= 0 {
+		return nil, fmt.Errorf("LOGIN_IP_MAX_FAILURES must be a positive integer")
+	}
+	cfg.LoginBackoffBase, err = time.ParseDuration(getEnv("LOGIN_BACKOFF_BASE", "1s"))
+	if err != nil || cfg.LoginBackoffBase <= 0 {
+		return nil, fmt.Errorf("LOGIN_BACKOFF_BASE must be a positive duration")
+	}
+	cfg.LoginLockoutDuration, err = time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m"))
+	if err != nil || cfg.LoginLockoutDuration <= 0 {
+		return nil, fmt.Errorf("LOGIN_LOCKOUT_DURATION must be a positive duration")
+	}
+
+	holdTTL, err := time.ParseDuration(getEnv("HOLD_TTL", "168h"))
+	if err != nil || holdTTL <= 0 {
+		return nil, fmt.Errorf("HOLD_TTL must be a positive duration")
+	}
+	cfg.HoldTTL = holdTTL
+
+	cfg.RecipientLookupLimit, err = strconv.Atoi(getEnv("RECIPIENT_LOOKUP_LIMIT", "20"))
+	if err != nil || cfg.RecipientLookupLimit <= 0 {
+		return nil, fmt.Errorf("RECIPIENT_LOOKUP_LIMIT must be a positive integer")
+	}
+	cfg.RecipientLookupSpan, err = time.ParseDuration(getEnv("RECIPIENT_LOOKUP_SPAN", "1h"))
+	if err != nil || cfg.RecipientLookupSpan <= 0 {
+		return nil, fmt.Errorf("RECIPIENT_LOOKUP_SPAN must be a positive duration")
+	}
+
+	cfg.CardPINMaxAttempts, err = strconv.Atoi(getEnv("CARD_PIN_MAX_ATTEMPTS", "3"))
+	if err != nil || cfg.CardPINMaxAttempts <= 0 {
+		return nil, fmt.Errorf("CARD_PIN_MAX_ATTEMPTS must be a positive integer")
+	}
+
+	cardBINRanges, err := parseBINRanges(getEnv("CARD_BIN_RANGES", "visa:400000-400999,mastercard:510000-510999,mir:220000-220499"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CARD_BIN_RANGES: %w", err)
+	}
+	cfg.CardBINRanges = cardBINRanges
+	if _, ok := cfg.CardBINRanges[cfg.DefaultPaymentSystem]; !ok {
+		return nil, fmt.Errorf("CARD_DEFAULT_PAYMENT_SYSTEM %q has no BIN ranges", cfg.DefaultPaymentSystem)
+	}
+
+	if len(cfg.BankBIC) != 9 || !isDigits(cfg.BankBIC) {
+		return nil, fmt.Errorf("BANK_BIC must be 9 digits")
+	}
+	if len(cfg.AccountBalanceNumber) != 5 || !isDigits(cfg.AccountBalanceNumber) {
+		return nil, fmt.Errorf("ACCOUNT_BALANCE_NUMBER must be 5 digits")
+	}
+	if len(cfg.AccountBranchCode) != 4 || !isDigits(cfg.AccountBranchCode) {
+		return nil, fmt.Errorf("ACCOUNT_BRANCH_CODE must be 4 digits")
+	}
+
+	// Without an explicit keyring the legacy key becomes key version 1
+	encryptionKeys, err := parseEncryptionKeys(getEnv("ENCRYPTION_KEYS", "1:"+cfg.EncryptionKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+	}
+	cfg.EncryptionKeys = encryptionKeys
+	cfg.EncryptionKeyVersion, err = strconv.Atoi(getEnv("ENCRYPTION_KEY_VERSION", "1"))
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY_VERSION must be an integer")
+	}
+	if _, ok := cfg.EncryptionKeys[cfg.EncryptionKeyVersion]; !ok {
+		return nil, fmt.Errorf("ENCRYPTION_KEY_VERSION %d is not in ENCRYPTION_KEYS", cfg.EncryptionKeyVersion)
 	}
 
 	if cfg.DBConn == "" {
 		return nil, fmt.Errorf("DB_CONN is required")
 	}
-	if cfg.JWTSecret == "" {
-		return nil, fmt.Errorf("JWT_SECRET is required")
+	if cfg.JWTKeysDir == "" && cfg.AppEnv == EnvProduction {
+		return nil, fmt.Errorf("JWT_KEYS_DIR is required when APP_ENV is %s", EnvProduction)
+	}
+	if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+		return nil, fmt.Errorf("JWT_ISSUER and JWT_AUDIENCE are required")
 	}
 	if cfg.HMACSecret == "" {
 		return nil, fmt.Errorf("HMAC_SECRET is required")
@@ -50,6 +227,21 @@ func NewConfig() (*Config, error) {
 	if cfg.EncryptionKey == "" {
 		return nil, fmt.Errorf("ENCRYPTION_KEY is required")
 	}
+	if cfg.EmailTokenKey == "" {
+		return nil, fmt.Errorf("EMAIL_TOKEN_KEY is required")
+	}
+	if cfg.MFATokenKey == "" {
+		return nil, fmt.Errorf("MFA_TOKEN_KEY is required")
+	}
+	if cfg.PANFingerprintKey == "" {
+		return nil, fmt.Errorf("PAN_FINGERPRINT_KEY is required")
+	}
+	// The development defaults of these keys are public, so production must set its own
+	for _, key := range []string{"EMAIL_TOKEN_KEY", "MFA_TOKEN_KEY", "PAN_FINGERPRINT_KEY"} {
+		if _, exists := os.LookupEnv(key); !exists && cfg.AppEnv == EnvProduction {
+			return nil, fmt.Errorf("%s is required when APP_ENV is %s", key, EnvProduction)
+		}
+	}
 	if cfg.SMTPUsername == "" || cfg.SMTPPassword == "" {
 		return nil, fmt.Errorf("SMTP_USERNAME and SMTP_PASSWORD must be set")
 	}
@@ -63,3 +255,68 @@ func getEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+// parseBINRanges parses comma-separated "system:low-high" entries; a single BIN may be given as "system:bin"
+func parseBINRanges(value string) (map[string][]BINRange, error) {
+	ranges := make(map[string][]BINRange)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		system, bins, ok := strings.Cut(entry, ":")
+		if !ok || system == "" {
+			return nil, fmt.Errorf("entry %q must have the form system:low-high", entry)
+		}
+		low, high, ok := strings.Cut(bins, "-")
+		if !ok {
+			high = low
+		}
+		if len(low) < 6 || len(low) > 8 || len(low) != len(high) || !isDigits(low) || !isDigits(high) || low > high {
+			return nil, fmt.Errorf("entry %q must contain 6 to 8 digit prefixes of equal length with low <= high", entry)
+		}
+		system = strings.ToLower(system)
+		ranges[system] = append(ranges[system], BINRange{Low: low, High: high})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("at least one BIN range is required")
+	}
+	return ranges, nil
+}
+
+// parseEncryptionKeys parses comma-separated "version:hexkey" entries of 32-byte keys
+func parseEncryptionKeys(value string) (map[int][]byte, error) {
+	keys := make(map[int][]byte)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		versionStr, keyHex, ok := strings.Cut(entry, ":")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("entries must have the form version:hexkey with a positive version")
+		}
+		key, err := hex.DecodeString(keyHex)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key version %d must be 32 hex-encoded bytes", version)
+		}
+		if _, exists := keys[version]; exists {
+			return nil, fmt.Errorf("key version %d is defined twice", version)
+		}
+		keys[version] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+	return keys, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
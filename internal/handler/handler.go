@@ -2,10 +2,12 @@ package handler
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Dan9191/bank-service/internal/models"
 	"github.com/Dan9191/bank-service/internal/service"
 	"github.com/gorilla/mux"
 )
@@ -53,13 +55,304 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.svc.Login(req.Email, req.Password)
+	tokens, challenge, err := h.svc.Login(r.Context(), req.Email, req.Password, clientIP(r), r.UserAgent())
 	if err != nil {
+		switch err.Error() {
+		case "too many login attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case "invalid credentials":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "user frozen", "user closed":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if challenge != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// CompleteMFALogin handles the second step of a login with two-factor authentication
+func (h *Handler) CompleteMFALogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"` // TOTP or recovery code
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.svc.CompleteMFALogin(r.Context(), req.MFAToken, req.Code, clientIP(r), r.UserAgent())
+	if err != nil {
+		switch err.Error() {
+		case "too many login attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case "invalid or expired MFA token", "invalid one-time code":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "user frozen", "user closed":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// SetupTOTP handles starting two-factor enrollment
+func (h *Handler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.svc.SetupTOTP(r.Context(), req.Password)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// EnableTOTP handles confirming two-factor enrollment and returns the recovery codes
+func (h *Handler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := h.svc.EnableTOTP(r.Context(), req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": recoveryCodes})
+}
+
+// DisableTOTP handles turning off two-factor authentication
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.DisableTOTP(r.Context(), req.Password, req.Code); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTwoFactorError maps errors of two-factor enrollment to HTTP statuses
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "re-authentication failed", "step-up verification required", "invalid one-time code":
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case "too many verification attempts":
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case "two-factor authentication already enabled", "two-factor authentication not enabled", "two-factor enrollment not started":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writeTokens writes an issued token pair. The access token is also returned as "token"
+// for clients predating refresh tokens.
+func writeTokens(w http.ResponseWriter, tokens *models.TokenPair) {
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+		*models.TokenPair
+	}{tokens.AccessToken, tokens})
+}
+
+// writeMoneyMovementError maps errors of deposits, withdrawals, transfers and credits to HTTP statuses
+func writeMoneyMovementError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "email not verified":
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case "step-up verification required", "re-authentication failed":
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case "too many verification attempts":
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case "account frozen", "account closed":
+		http.Error(w, err.Error(), http.StatusConflict)
+	case "recipient not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// VerifyEmail handles verifying an email address with a token sent to it
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.VerifyEmail(req.Token); err != nil {
+		if err.Error() == "email already registered" {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendEmailVerification handles sending a new verification email
+func (h *Handler) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.ResendEmailVerification(r.Context()); err != nil {
+		switch err.Error() {
+		case "email already verified":
+			http.Error(w, err.Error(), http.StatusConflict)
+		case "verification email recently sent":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ForgotPassword handles requesting a password reset email. The response does not reveal
+// whether the email belongs to a user.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.svc.ForgotPassword(req.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword handles setting a new password with a password reset token
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.ResetPassword(r.Context(), req.Token, req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword handles changing the password of the authenticated user
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+		OTP             string `json:"otp"` // Required when two-factor authentication is enabled
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.svc.ChangePassword(r.Context(), req.CurrentPassword, req.NewPassword, req.OTP, clientIP(r), r.UserAgent())
+	if err != nil {
+		switch err.Error() {
+		case "re-authentication failed", "step-up verification required":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "too many verification attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// RefreshToken handles exchanging a refresh token for a new token pair
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.svc.RefreshToken(r.Context(), req.RefreshToken, clientIP(r))
+	if err != nil {
+		switch err.Error() {
+		case "invalid refresh token", "refresh token reuse detected":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "user frozen", "user closed":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// Logout handles revoking the current session's tokens
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Logout(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll handles revoking all of the user's tokens
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.LogoutAll(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // CreateAccount handles account creation
@@ -89,14 +382,17 @@ func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 // CreateCard handles card creation
 func (h *Handler) CreateCard(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		AccountID int64 `json:"account_id"`
+		AccountID     int64    `json:"account_id"`
+		PaymentSystem string   `json:"payment_system"`
+		CardType      string   `json:"card_type"`
+		SpendCap      *float64 `json:"spend_cap"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	card, err := h.svc.CreateCard(r.Context(), req.AccountID)
+	card, err := h.svc.CreateCard(r.Context(), req.AccountID, req.PaymentSystem, req.CardType, req.SpendCap)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -120,7 +416,7 @@ func (h *Handler) CreateCredit(w http.ResponseWriter, r *http.Request) {
 
 	credit, err := h.svc.CreateCredit(r.Context(), req.AccountID, req.Amount, req.TermMonths)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeMoneyMovementError(w, err)
 		return
 	}
 
@@ -216,7 +512,7 @@ func (h *Handler) Deposit(w http.ResponseWriter, r *http.Request) {
 
 	transaction, err := h.svc.Deposit(r.Context(), req.AccountID, req.Amount)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeMoneyMovementError(w, err)
 		return
 	}
 
@@ -237,7 +533,7 @@ func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 
 	transaction, err := h.svc.Withdraw(r.Context(), req.AccountID, req.Amount)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeMoneyMovementError(w, err)
 		return
 	}
 
@@ -245,21 +541,66 @@ func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(transaction)
 }
 
+// transferParties holds the sender account and recipient fields of transfer requests. The
+// recipient is given by exactly one of account ID, account number, email or card number.
+type transferParties struct {
+	FromAccountID     int64  `json:"from_account_id"`
+	FromAccountNumber string `json:"from_account_number"` // Alternative to from_account_id
+	ToAccountID       int64  `json:"to_account_id"`
+	ToAccountNumber   string `json:"to_account_number"`
+	ToEmail           string `json:"to_email"`
+	ToCardNumber      string `json:"to_card_number"`
+}
+
+func (p transferParties) from() models.AccountRef {
+	return models.AccountRef{ID: p.FromAccountID, Number: p.FromAccountNumber}
+}
+
+func (p transferParties) to() models.TransferRecipient {
+	return models.TransferRecipient{
+		AccountRef: models.AccountRef{ID: p.ToAccountID, Number: p.ToAccountNumber},
+		Email:      p.ToEmail,
+		CardNumber: p.ToCardNumber,
+	}
+}
+
+// PreviewTransferRecipient handles resolving a transfer recipient to a masked name before
+// the transfer is confirmed
+func (h *Handler) PreviewTransferRecipient(w http.ResponseWriter, r *http.Request) {
+	var req transferParties
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := h.svc.PreviewTransferRecipient(r.Context(), req.from(), req.to(), clientIP(r), r.UserAgent())
+	if err != nil {
+		if err.Error() == "too many recipient lookups" {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		} else {
+			writeMoneyMovementError(w, err)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(preview)
+}
+
 // Transfer handles transferring funds between accounts
 func (h *Handler) Transfer(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		FromAccountID int64   `json:"from_account_id"`
-		ToAccountID   int64   `json:"to_account_id"`
+		transferParties
 		Amount        float64 `json:"amount"`
+		models.StepUp         // Required above the step-up limit
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	transactions, err := h.svc.Transfer(r.Context(), req.FromAccountID, req.ToAccountID, req.Amount)
+	transactions, err := h.svc.Transfer(r.Context(), req.from(), req.to(), req.Amount, req.StepUp)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeMoneyMovementError(w, err)
 		return
 	}
 
@@ -299,6 +640,44 @@ func (h *Handler) ListTransactions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(transactions)
 }
 
+// ReverseTransaction handles reversing a transaction
+func (h *Handler) ReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	transactionID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	transactions, err := h.svc.ReverseTransaction(r.Context(), transactionID, req.Reason)
+	if err != nil {
+		switch err.Error() {
+		case "insufficient role":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case "transaction not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "transaction already reversed", "account frozen", "account closed":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transactions)
+}
+
 // ListCards handles retrieving cards for a user or account
 func (h *Handler) ListCards(w http.ResponseWriter, r *http.Request) {
 	accountIDStr := r.URL.Query().Get("account_id")
@@ -333,3 +712,893 @@ func (h *Handler) ListCards(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(cards)
 }
+
+// ListAccounts handles retrieving the accounts of the authenticated user
+func (h *Handler) ListAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.svc.ListAccounts(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// GetAccount handles retrieving the details of an account
+func (h *Handler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.svc.GetAccount(r.Context(), accountID)
+	if err != nil {
+		if err.Error() == "account not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+// CloseAccount handles closing an account, optionally sweeping its balance to another account
+func (h *Handler) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		SweepToAccountID int64 `json:"sweep_to_account_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sweep, err := h.svc.CloseAccount(r.Context(), accountID, req.SweepToAccountID)
+	if err != nil {
+		switch err.Error() {
+		case "account not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "account frozen", "account closed", "account has pending holds", "account has open credits",
+			"account has a negative balance", "account has a non-zero balance":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Transactions []*models.Transaction `json:"transactions"`
+	}{sweep})
+}
+
+// GetAccountBalance handles retrieving the ledger and available balances of an account
+func (h *Handler) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.svc.GetAccountBalance(r.Context(), accountID)
+	if err != nil {
+		if err.Error() == "account not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+// CreateHold handles placing an authorization hold on an account
+func (h *Handler) CreateHold(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID   int64   `json:"account_id"`
+		Amount      float64 `json:"amount"`
+		Description string  `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := h.svc.CreateHold(r.Context(), req.AccountID, req.Amount, req.Description)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+// CaptureHold handles capturing all or part of a hold
+func (h *Handler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	holdID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"` // Omit to capture the full hold
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	hold, err := h.svc.CaptureHold(r.Context(), holdID, req.Amount)
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hold)
+}
+
+// ReleaseHold handles releasing a hold
+func (h *Handler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	holdID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := h.svc.ReleaseHold(r.Context(), holdID)
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hold)
+}
+
+// writeHoldError maps hold errors to HTTP statuses
+func writeHoldError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "hold not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "hold is not pending", "account frozen", "account closed":
+		http.Error(w, err.Error(), http.StatusConflict)
+	case "hold is settled by the card network":
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// ListHolds handles retrieving holds for an account
+func (h *Handler) ListHolds(w http.ResponseWriter, r *http.Request) {
+	accountIDStr := r.URL.Query().Get("account_id")
+	status := r.URL.Query().Get("status")
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account_id", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		limit = 10 // Default limit
+	}
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	holds, err := h.svc.ListHolds(r.Context(), accountID, status, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(holds)
+}
+
+// AuthorizeCardPayment handles card payment authorization requests from the processing network
+func (h *Handler) AuthorizeCardPayment(w http.ResponseWriter, r *http.Request) {
+	var req models.CardAuthorizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	auth, err := h.svc.AuthorizeCardPayment(r.Context(), &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(auth)
+}
+
+// CaptureCardPayment handles the processing network settling a card authorization
+func (h *Handler) CaptureCardPayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	authID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card authorization ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"` // Defaults to the authorized amount
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	auth, err := h.svc.CaptureCardPayment(r.Context(), authID, req.Amount)
+	if err != nil {
+		writeCardPaymentError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(auth)
+}
+
+// ReverseCardPayment handles the processing network cancelling a card authorization
+func (h *Handler) ReverseCardPayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	authID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card authorization ID", http.StatusBadRequest)
+		return
+	}
+
+	auth, err := h.svc.ReverseCardPayment(r.Context(), authID)
+	if err != nil {
+		writeCardPaymentError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(auth)
+}
+
+// writeCardPaymentError maps errors of settling card authorizations to HTTP statuses
+func writeCardPaymentError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "card authorization not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "card payment not approved", "card payment already captured", "card payment already reversed",
+		"hold is not pending", "transaction already reversed", "account frozen", "account closed":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// UpdateCardStatus handles blocking, unblocking and closing a card
+func (h *Handler) UpdateCardStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	card, err := h.svc.UpdateCardStatus(r.Context(), cardID, req.Status)
+	if err != nil {
+		writeCardError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(card)
+}
+
+// ReissueCard handles replacing a card with a newly issued one
+func (h *Handler) ReissueCard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	card, err := h.svc.ReissueCard(r.Context(), cardID)
+	if err != nil {
+		writeCardError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(card)
+}
+
+// writeCardError maps card errors to HTTP statuses
+func writeCardError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "card not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "card status changed concurrently":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// StartCardReencryption handles starting the rotation of card data to the active encryption key
+func (h *Handler) StartCardReencryption(w http.ResponseWriter, r *http.Request) {
+	job, err := h.svc.StartCardReencryption(r.Context())
+	if err != nil {
+		switch err.Error() {
+		case "insufficient role":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case "re-encryption job already running":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetCardReencryption handles retrieving the progress of a re-encryption job
+func (h *Handler) GetCardReencryption(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.svc.GetCardReencryption(r.Context(), jobID)
+	if err != nil {
+		switch err.Error() {
+		case "insufficient role":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case "re-encryption job not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+// UnlockUser handles lifting a login lockout of a user
+func (h *Handler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.UnlockUser(r.Context(), userID); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SearchUsers handles searching users by email, username or ID
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	limit, offset := 20, 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := h.svc.SearchUsers(r.Context(), query, limit, offset)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(users)
+}
+
+// AdminGetUser handles retrieving any user
+func (h *Handler) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.svc.AdminGetUser(r.Context(), userID)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// FreezeUser handles freezing a user
+func (h *Handler) FreezeUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserFrozen(w, r, true)
+}
+
+// UnfreezeUser handles unfreezing a user
+func (h *Handler) UnfreezeUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserFrozen(w, r, false)
+}
+
+func (h *Handler) setUserFrozen(w http.ResponseWriter, r *http.Request, frozen bool) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SetUserFrozen(r.Context(), userID, frozen); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FreezeAccount handles freezing an account
+func (h *Handler) FreezeAccount(w http.ResponseWriter, r *http.Request) {
+	h.setAccountFrozen(w, r, true)
+}
+
+// UnfreezeAccount handles unfreezing an account
+func (h *Handler) UnfreezeAccount(w http.ResponseWriter, r *http.Request) {
+	h.setAccountFrozen(w, r, false)
+}
+
+func (h *Handler) setAccountFrozen(w http.ResponseWriter, r *http.Request, frozen bool) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SetAccountFrozen(r.Context(), accountID, frozen); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRole handles changing the role of a user
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SetUserRole(r.Context(), userID, req.Role); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminGetAccount handles retrieving any account
+func (h *Handler) AdminGetAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.svc.AdminGetAccount(r.Context(), accountID)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+// AdminGetCredit handles retrieving any credit with its payment schedule
+func (h *Handler) AdminGetCredit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	creditID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid credit ID", http.StatusBadRequest)
+		return
+	}
+
+	credit, payments, err := h.svc.AdminGetCredit(r.Context(), creditID)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		*models.Credit
+		Payments []*models.PaymentSchedule `json:"payments"`
+	}{credit, payments})
+}
+
+// AdminGetCard handles retrieving any card
+func (h *Handler) AdminGetCard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	card, err := h.svc.AdminGetCard(r.Context(), cardID)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(card)
+}
+
+// writeAdminError maps errors of staff operations to HTTP statuses
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "insufficient role":
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case "user not found", "account not found", "credit not found", "card not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "account closed":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// RevealCard handles revealing the full details of a card after re-authentication
+func (h *Handler) RevealCard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.StepUp
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	card, err := h.svc.RevealCard(r.Context(), cardID, req, clientIP(r), r.UserAgent())
+	if err != nil {
+		switch err.Error() {
+		case "re-authentication failed", "step-up verification required":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "too many verification attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			writeCardError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(card)
+}
+
+// clientIP returns the IP address of the client connected to the server
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GetCardLimits handles retrieving the spending limits of a card
+func (h *Handler) GetCardLimits(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	limits, err := h.svc.GetCardLimits(r.Context(), cardID)
+	if err != nil {
+		writeCardError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(limits)
+}
+
+// SetCardLimits handles replacing the spending limits of a card
+func (h *Handler) SetCardLimits(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		DailyLimit          *float64 `json:"daily_limit"`
+		MonthlyLimit        *float64 `json:"monthly_limit"`
+		PerTransactionLimit *float64 `json:"per_transaction_limit"`
+		OnlineEnabled       *bool    `json:"online_enabled"`
+		ATMEnabled          *bool    `json:"atm_enabled"`
+		ContactlessEnabled  *bool    `json:"contactless_enabled"`
+		BlockedMCCs         []string `json:"blocked_mccs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Channels are enabled unless explicitly disabled
+	limits := &models.CardLimits{
+		CardID:              cardID,
+		DailyLimit:          req.DailyLimit,
+		MonthlyLimit:        req.MonthlyLimit,
+		PerTransactionLimit: req.PerTransactionLimit,
+		OnlineEnabled:       req.OnlineEnabled == nil || *req.OnlineEnabled,
+		ATMEnabled:          req.ATMEnabled == nil || *req.ATMEnabled,
+		ContactlessEnabled:  req.ContactlessEnabled == nil || *req.ContactlessEnabled,
+		BlockedMCCs:         req.BlockedMCCs,
+	}
+
+	limits, err = h.svc.SetCardLimits(r.Context(), limits)
+	if err != nil {
+		writeCardError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(limits)
+}
+
+// SetCardPIN handles setting or changing the PIN of a card
+func (h *Handler) SetCardPIN(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		PIN      string `json:"pin"`
+		Password string `json:"password"`
+		OTP      string `json:"otp"` // Required to unblock a card blocked by wrong PINs
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SetCardPIN(r.Context(), cardID, req.PIN, req.Password, req.OTP); err != nil {
+		switch err.Error() {
+		case "re-authentication failed", "step-up verification required":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "too many verification attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			writeCardError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSessions handles listing the active sessions of the authenticated user
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.svc.ListSessions(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession handles signing out one session of the authenticated user
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RevokeSession(r.Context(), sessionID); err != nil {
+		if err.Error() == "session not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateAPIKey handles creating a personal API key
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	apiKey, err := h.svc.CreateAPIKey(r.Context(), req.Name, req.Scopes)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+// ListAPIKeys handles listing the personal API keys of the authenticated user
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	apiKeys, err := h.svc.ListAPIKeys(r.Context())
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(apiKeys)
+}
+
+// RevokeAPIKey handles revoking a personal API key
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RevokeAPIKey(r.Context(), keyID); err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAPIKeyError maps errors of API key management to HTTP statuses
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "API keys cannot manage API keys":
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case "API key not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "API key limit reached":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// GetProfile handles returning the profile of the authenticated user
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := h.svc.GetProfile(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateProfile handles changing the profile of the authenticated user
+func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var req models.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.svc.UpdateProfile(r.Context(), req)
+	if err != nil {
+		switch err.Error() {
+		case "re-authentication failed", "step-up verification required":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "too many verification attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case "username already taken", "email already registered", "phone already registered":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// CloseUser handles closing the profile of the authenticated user
+func (h *Handler) CloseUser(w http.ResponseWriter, r *http.Request) {
+	var req models.StepUp
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.CloseUser(r.Context(), req); err != nil {
+		switch err.Error() {
+		case "re-authentication failed", "step-up verification required":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "too many verification attempts":
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case "accounts have a non-zero balance", "user has open credits", "user already closed":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
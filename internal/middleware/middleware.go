@@ -1,36 +1,160 @@
 package middleware
 
 import (
-	"context"
+	"crypto/subtle"
 	"net/http"
 	"strings"
 
+	"github.com/Dan9191/bank-service/internal/auth"
 	"github.com/Dan9191/bank-service/internal/config"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 )
 
-// AuthMiddleware checks JWT token
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// TokenRevocationChecker reports whether an access token has been revoked
+type TokenRevocationChecker interface {
+	IsTokenRevoked(jti string) (bool, error)
+}
+
+// SessionToucher records the use of an access token in its session
+type SessionToucher interface {
+	TouchSession(jti string)
+}
+
+// APIKeyAuthenticator resolves the principal of a personal API key
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(key string) (*auth.Principal, error)
+}
+
+// Authenticator verifies the credentials accepted by AuthMiddleware
+type Authenticator interface {
+	TokenRevocationChecker
+	SessionToucher
+	APIKeyAuthenticator
+}
+
+// AuthMiddleware checks JWT token and rejects revoked tokens. Requests may instead carry a
+// personal API key in the X-API-Key header, which is only accepted on routes wrapped with
+// RequireScope.
+func AuthMiddleware(signer *auth.TokenSigner, authn Authenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
+			if apiKey := r.Header.Get("X-API-Key"); apiKey != "" && authHeader == "" {
+				principal, err := authn.AuthenticateAPIKey(apiKey)
+				if err != nil {
+					if err.Error() == "invalid API key" {
+						http.Error(w, "Invalid API key", http.StatusUnauthorized)
+					} else {
+						http.Error(w, "Failed to validate API key", http.StatusInternalServerError)
+					}
+					return
+				}
+				if !acceptsAPIKeys(r) {
+					http.Error(w, "API keys are not accepted on this route", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+				return
+			}
 			if authHeader == "" {
 				http.Error(w, "Authorization header required", http.StatusUnauthorized)
 				return
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			claims := &jwt.RegisteredClaims{}
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(cfg.JWTSecret), nil
-			})
-			if err != nil || !token.Valid {
+			claims, err := signer.ParseAccessToken(tokenString)
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			revoked, err := authn.IsTokenRevoked(claims.ID)
+			if err != nil {
+				http.Error(w, "Failed to validate token", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token revoked", http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := auth.NewPrincipal(claims)
+			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
+			authn.TouchSession(claims.ID)
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// RequireRole rejects requests whose access token does not carry one of the given roles.
+// It must run after AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok || !principal.HasRole(roles...) {
+				http.Error(w, "Insufficient role", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// scopedHandler is a handler that accepts API keys with one of its scopes
+type scopedHandler struct {
+	scopes []string
+	next   http.Handler
+}
+
+func (h scopedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok || !principal.HasScope(h.scopes...) {
+		http.Error(w, "Insufficient API key scope", http.StatusForbidden)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// RequireScope allows API keys with any of the given scopes to use a route. Routes that are
+// not wrapped reject API keys. Access tokens are not limited by scopes.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return scopedHandler{scopes: scopes, next: next}
+	}
+}
+
+// acceptsAPIKeys reports whether the matched route is wrapped with RequireScope
+func acceptsAPIKeys(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	_, ok := route.GetHandler().(scopedHandler)
+	return ok
+}
+
+// ProcessingKeyMiddleware authenticates the card processing network by its API key.
+// Card payment routes are disabled when no key is configured.
+func ProcessingKeyMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.ProcessingAPIKey == "" {
+				http.Error(w, "Card processing is disabled", http.StatusServiceUnavailable)
+				return
+			}
+
+			key := r.Header.Get("X-Processing-Key")
+			if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.ProcessingAPIKey)) != 1 {
+				http.Error(w, "Invalid processing key", http.StatusUnauthorized)
+				return
+			}
 
-			ctx := context.WithValue(r.Context(), "userID", claims.Subject)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -1,10 +1,26 @@
 package models
 
+// Account statuses
+const (
+	AccountStatusActive = "active"
+	AccountStatusFrozen = "frozen" // No money moves in or out until unfrozen by staff
+	AccountStatusClosed = "closed" // Final; kept for the financial record
+)
+
 type Account struct {
-	ID        int64   `json:"id"`
-	UserID    int64   `json:"user_id"`
-	Balance   float64 `json:"balance"`
-	Currency  string  `json:"currency"`
-	CreatedAt string  `json:"created_at"`
-	UpdatedAt string  `json:"updated_at"`
+	ID               int64   `json:"id"`
+	UserID           int64   `json:"user_id"`
+	Number           string  `json:"number"`            // 20-digit account number
+	Balance          float64 `json:"balance"`           // Ledger balance of posted transactions
+	AvailableBalance float64 `json:"available_balance"` // Ledger balance minus pending holds
+	Currency         string  `json:"currency"`
+	Status           string  `json:"status"`
+	CreatedAt        string  `json:"created_at"`
+	UpdatedAt        string  `json:"updated_at"`
+}
+
+// AccountRef identifies an account by its ID or by its account number
+type AccountRef struct {
+	ID     int64
+	Number string
 }
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// API key scopes. Routes that accept API keys require one of these scopes.
+const (
+	APIKeyScopeRead      = "read"      // Read accounts, cards, transactions and analytics
+	APIKeyScopeTransfers = "transfers" // Transfer funds between accounts
+	APIKeyScopeWrite     = "write"     // All customer operations available to API keys, including transfers
+)
+
+// ValidAPIKeyScope reports whether scope is a known API key scope
+func ValidAPIKeyScope(scope string) bool {
+	switch scope {
+	case APIKeyScopeRead, APIKeyScopeTransfers, APIKeyScopeWrite:
+		return true
+	}
+	return false
+}
+
+// AuthMethodAPIKey is recorded as the authentication method of requests made with an API key
+const AuthMethodAPIKey = "apikey"
+
+// APIKey represents a personal API key. Only its hash is stored; the key itself is returned once on creation.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // Leading characters of the key, to tell keys apart
+	KeyHash    string     `json:"-"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
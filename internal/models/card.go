@@ -1,13 +1,53 @@
 package models
 
+import "time"
+
+// Card statuses
+const (
+	CardStatusActive             = "active"
+	CardStatusTemporarilyBlocked = "temporarily_blocked"
+	CardStatusLostStolen         = "lost_stolen"
+	CardStatusPINBlocked         = "pin_blocked" // Too many wrong PIN attempts
+	CardStatusExpired            = "expired"
+	CardStatusClosed             = "closed"
+)
+
+// Card types
+const (
+	CardTypePhysical  = "physical"
+	CardTypeVirtual   = "virtual"
+	CardTypeSingleUse = "single_use" // Closed after the first approved authorization
+)
+
 // Card represents a bank card
 type Card struct {
-	ID         int64  `json:"id"`
-	AccountID  int64  `json:"account_id"`
-	CardNumber string `json:"card_number"` // Decrypted for response
-	ExpiryDate string `json:"expiry_date"` // Decrypted for response
-	CVV        string `json:"-"`           // Not serialized
-	HMAC       string `json:"hmac"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
+	ID             int64      `json:"id"`
+	AccountID      int64      `json:"account_id"`
+	CardNumber     string     `json:"card_number,omitempty"` // Decrypted only on issue and reveal
+	ExpiryDate     string     `json:"expiry_date,omitempty"` // Decrypted only on issue and reveal
+	MaskedPAN      string     `json:"masked_pan"`            // First 6 and last 4 digits
+	CVV            string     `json:"-"`                     // Not serialized
+	HMAC           string     `json:"-"`
+	PINHash        string     `json:"-"`
+	PINSet         bool       `json:"pin_set"`
+	PaymentSystem  string     `json:"payment_system"`
+	CardType       string     `json:"card_type"`
+	SpendCap       *float64   `json:"spend_cap,omitempty"` // Card is closed once total spending reaches the cap
+	TotalSpent     float64    `json:"total_spent"`
+	PANFingerprint string     `json:"-"` // Keyed hash of the card number for lookups
+	Status         string     `json:"status"`
+	ExpiresOn      *time.Time `json:"-"` // Last day the card is valid, kept unencrypted for the expiry job
+	ReissuedFrom   *int64     `json:"reissued_from,omitempty"`
+	CreatedAt      string     `json:"created_at"`
+	UpdatedAt      string     `json:"updated_at"`
+}
+
+// CardReveal is an audit record of a card's full details being revealed to its owner
+type CardReveal struct {
+	ID        int64     `json:"id"`
+	CardID    int64     `json:"card_id"`
+	UserID    int64     `json:"user_id"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// ISO 8583 response codes returned by card authorization
+const (
+	ResponseApproved          = "00"
+	ResponseInvalidMerchant   = "03"
+	ResponseDoNotHonor        = "05"
+	ResponseInvalidAmount     = "13"
+	ResponseInvalidCard       = "14"
+	ResponseLostCard          = "41"
+	ResponseInsufficientFunds = "51"
+	ResponseIncorrectPIN      = "55"
+	ResponseExpiredCard       = "54"
+	ResponseNotPermitted      = "57"
+	ResponseExceedsLimit      = "61"
+	ResponseRestrictedCard    = "62"
+	ResponsePINTriesExceeded  = "75"
+	ResponseSystemError       = "96"
+	ResponseCVVFailure        = "N7"
+)
+
+// CardAuthorizationRequest represents an authorization request received from the processing network
+type CardAuthorizationRequest struct {
+	PAN          string  `json:"pan"`
+	Expiry       string  `json:"expiry"` // Format: MM/YY
+	CVV          string  `json:"cvv"`
+	PIN          string  `json:"pin"` // Required for ATM withdrawals
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"`
+	MerchantID   string  `json:"merchant_id"`
+	MerchantName string  `json:"merchant_name"`
+	MCC          string  `json:"mcc"`     // Merchant category code
+	Channel      string  `json:"channel"` // pos, online, atm or contactless; defaults to pos
+	Capture      bool    `json:"capture"` // Debit immediately instead of placing a hold
+}
+
+// CardAuthorization represents the outcome of a card authorization request
+type CardAuthorization struct {
+	ID            int64      `json:"id"`
+	CardID        *int64     `json:"-"`
+	Amount        float64    `json:"amount"`
+	Currency      string     `json:"currency"`
+	MerchantID    string     `json:"merchant_id"`
+	MerchantName  string     `json:"merchant_name"`
+	MCC           string     `json:"mcc"`
+	Channel       string     `json:"channel"`
+	ResponseCode  string     `json:"response_code"`
+	Approved      bool       `json:"approved"`
+	AuthCode      string     `json:"auth_code,omitempty"`
+	Message       string     `json:"message"`
+	TransactionID *int64     `json:"transaction_id,omitempty"`
+	HoldID        *int64     `json:"hold_id,omitempty"`
+	ReversedAt    *time.Time `json:"reversed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Card payment channels
+const (
+	CardChannelPOS         = "pos"
+	CardChannelOnline      = "online"
+	CardChannelATM         = "atm"
+	CardChannelContactless = "contactless"
+)
+
+// CardLimits represents the spending limits and usage controls of a card. Nil limits are unlimited.
+type CardLimits struct {
+	CardID              int64     `json:"card_id"`
+	DailyLimit          *float64  `json:"daily_limit"`
+	MonthlyLimit        *float64  `json:"monthly_limit"`
+	PerTransactionLimit *float64  `json:"per_transaction_limit"`
+	OnlineEnabled       bool      `json:"online_enabled"`
+	ATMEnabled          bool      `json:"atm_enabled"`
+	ContactlessEnabled  bool      `json:"contactless_enabled"`
+	BlockedMCCs         []string  `json:"blocked_mccs"`
+	DailySpent          float64   `json:"daily_spent"`
+	MonthlySpent        float64   `json:"monthly_spent"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
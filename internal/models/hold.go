@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Hold statuses
+const (
+	HoldStatusPending  = "pending"
+	HoldStatusCaptured = "captured"
+	HoldStatusReleased = "released"
+	HoldStatusExpired  = "expired"
+)
+
+// Hold origins
+const (
+	HoldOriginCustomer    = "customer"     // Placed by the account owner, who may capture or release it
+	HoldOriginCardNetwork = "card_network" // Placed by a card authorization and settled by the processing network
+)
+
+// Hold represents an authorization hold that reserves funds on an account
+// without posting a transaction
+type Hold struct {
+	ID             int64     `json:"id"`
+	AccountID      int64     `json:"account_id"`
+	Amount         float64   `json:"amount"`
+	CapturedAmount float64   `json:"captured_amount"`
+	Status         string    `json:"status"`
+	Origin         string    `json:"origin"`
+	CardID         *int64    `json:"card_id,omitempty"` // Set for holds placed by a card authorization
+	Description    string    `json:"description"`
+	TransactionID  *int64    `json:"transaction_id,omitempty"` // Set once captured
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
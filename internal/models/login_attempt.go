@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Scopes of failed login tracking
+const (
+	LoginScopeEmail = "email"
+	LoginScopeIP    = "ip"
+)
+
+// LoginAttempts represents the failed login attempts recorded for an email address or client IP
+type LoginAttempts struct {
+	Scope         string    `json:"scope"`
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	BlockedUntil  time.Time `json:"blocked_until"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
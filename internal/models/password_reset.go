@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// PasswordResetToken represents a stored one-time password reset token
+type PasswordResetToken struct {
+	ID        int64
+	UserID    int64
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
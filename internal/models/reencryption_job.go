@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Re-encryption job statuses
+const (
+	ReencryptionJobRunning   = "running"
+	ReencryptionJobCompleted = "completed"
+	ReencryptionJobFailed    = "failed"
+)
+
+// ReencryptionJob tracks the rotation of stored card data to the active encryption key
+type ReencryptionJob struct {
+	ID         int64      `json:"id"`
+	KeyVersion int        `json:"key_version"`
+	Status     string     `json:"status"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	StartedBy  int64      `json:"started_by"`
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
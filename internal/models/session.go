@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session represents a login on a device. A session lasts as long as the refresh token family
+// started at login.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	FamilyID   string    `json:"-"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"` // Whether the request was made from this session
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// TokenPair represents the tokens issued on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// RefreshToken represents a stored refresh token. Tokens are rotated on every refresh;
+// all tokens descending from one login share a family, which is revoked as a whole.
+type RefreshToken struct {
+	ID              int64
+	UserID          int64
+	FamilyID        string
+	TokenHash       string
+	AccessJTI       string // ID of the access token issued alongside
+	AccessExpiresAt time.Time
+	AMR             []string // Authentication methods of the login, carried over on rotation
+	ExpiresAt       time.Time
+	UsedAt          *time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+}
@@ -1,5 +1,12 @@
 package models
 
+// Transaction statuses reported when listing transactions
+const (
+	TransactionStatusPosted   = "posted"
+	TransactionStatusReversed = "reversed"
+	TransactionStatusReversal = "reversal"
+)
+
 // Transaction represents a financial transaction
 type Transaction struct {
 	ID          int64   `json:"id"`
@@ -7,6 +14,10 @@ type Transaction struct {
 	Amount      float64 `json:"amount"`
 	Type        string  `json:"type"`
 	Description string  `json:"description"`
+	RelatedID   *int64  `json:"related_id,omitempty"`  // Other leg of a transfer
+	ReversalOf  *int64  `json:"reversal_of,omitempty"` // Original transaction compensated by this one
+	ReversedBy  *int64  `json:"reversed_by,omitempty"` // Compensating transaction, if reversed
+	Status      string  `json:"status"`
 	CreatedAt   string  `json:"created_at"`
 	UpdatedAt   string  `json:"updated_at"`
 }
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// TransferRecipient identifies the recipient of a transfer by exactly one of an account,
+// an email address or a card number
+type TransferRecipient struct {
+	AccountRef
+	Email      string
+	CardNumber string
+}
+
+// Recipient types of recipient lookups
+const (
+	RecipientTypeAccount = "account"
+	RecipientTypeEmail   = "email"
+	RecipientTypeCard    = "card"
+)
+
+// RecipientLookup is an audit record of a user previewing a transfer recipient. AccountID is
+// nil when no recipient was found.
+type RecipientLookup struct {
+	ID            int64     `json:"id"`
+	UserID        int64     `json:"user_id"`
+	RecipientType string    `json:"recipient_type"`
+	AccountID     *int64    `json:"account_id,omitempty"`
+	IPAddress     string    `json:"ip_address"`
+	UserAgent     string    `json:"user_agent"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// RecipientPreview describes a resolved transfer recipient for confirmation by the sender
+type RecipientPreview struct {
+	Name          string `json:"name"`           // Masked, e.g. "Ivan P."
+	AccountNumber string `json:"account_number"` // Masked to the last four digits
+	Currency      string `json:"currency"`
+}
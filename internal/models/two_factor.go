@@ -0,0 +1,27 @@
+package models
+
+// Authentication methods recorded in the amr claim of access tokens
+const (
+	AuthMethodPassword = "pwd"
+	AuthMethodOTP      = "otp"
+)
+
+// StepUp carries the re-authentication presented for a sensitive operation: a one-time
+// code when two-factor authentication is enabled, otherwise the password
+type StepUp struct {
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+}
+
+// TOTPEnrollment represents a pending TOTP secret to be added to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAChallenge is returned by login when a second factor is required
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"` // Seconds
+}
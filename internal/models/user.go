@@ -1,11 +1,51 @@
 package models
 
+import "time"
+
+// User roles
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"  // Read-only access to customer data
+	RoleOperator = "operator" // Support plus freezing and unlocking users
+	RoleAdmin    = "admin"
+)
+
+// ValidRole reports whether role is a known user role
+func ValidRole(role string) bool {
+	switch role {
+	case RoleCustomer, RoleSupport, RoleOperator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// ProfileUpdate holds the profile fields a user changes; nil fields are left unchanged
+type ProfileUpdate struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"` // Takes effect once the new address is verified
+	Phone    *string `json:"phone"` // Empty removes the phone number
+	FullName *string `json:"full_name"`
+	StepUp           // Required to change the email address
+}
+
 // User represents a user in the system
 type User struct {
-	ID           int64  `json:"id"`
-	Email        string `json:"email"`
-	Username     string `json:"username"`
-	PasswordHash string `json:"-"` // Not serialized
-	CreatedAt    string `json:"created_at"`
-	UpdatedAt    string `json:"updated_at"`
+	ID                      int64      `json:"id"`
+	Email                   string     `json:"email"`
+	PendingEmail            string     `json:"pending_email,omitempty"` // Requested new email, not yet verified
+	Username                string     `json:"username"`
+	FullName                string     `json:"full_name,omitempty"`
+	Phone                   string     `json:"phone,omitempty"` // E.164
+	PasswordHash            string     `json:"-"`               // Not serialized
+	Role                    string     `json:"role"`
+	EmailVerifiedAt         *time.Time `json:"email_verified_at,omitempty"`
+	EmailVerificationSentAt *time.Time `json:"-"`
+	TOTPSecret              string     `json:"-"` // Encrypted; set once enrollment starts
+	TOTPEnabledAt           *time.Time `json:"-"`
+	TOTPLastStep            int64      `json:"-"` // Last accepted time step, to reject replayed codes
+	TwoFactorEnabled        bool       `json:"two_factor_enabled"`
+	FrozenAt                *time.Time `json:"frozen_at,omitempty"` // Frozen users cannot sign in
+	ClosedAt                *time.Time `json:"closed_at,omitempty"` // Closed users are anonymised
+	CreatedAt               string     `json:"created_at"`
+	UpdatedAt               string     `json:"updated_at"`
 }
@@ -3,10 +3,13 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Dan9191/bank-service/internal/models"
+	"github.com/lib/pq"
 )
 
 // Repository provides database operations
@@ -24,24 +27,85 @@ func (r *Repository) CreateUser(user *models.User) error {
 	query := `
 		INSERT INTO bank.users (username, email, password_hash, created_at, updated_at)
 		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, created_at, updated_at`
+		RETURNING id, role, created_at, updated_at`
 	err := r.db.QueryRow(query, user.Username, user.Email, user.PasswordHash).
-		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+		Scan(&user.ID, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 	return nil
 }
 
-// FindUserByEmail retrieves a user by email
-func (r *Repository) FindUserByEmail(email string) (*models.User, error) {
+// userSelect selects all user columns
+const userSelect = `
+		SELECT
+			id,
+			username,
+			email,
+			COALESCE(pending_email, ''),
+			password_hash,
+			role,
+			created_at,
+			updated_at,
+			email_verified_at,
+			email_verification_sent_at,
+			COALESCE(totp_secret, ''),
+			totp_enabled_at,
+			totp_last_step,
+			frozen_at,
+			COALESCE(full_name, ''),
+			COALESCE(phone, ''),
+			closed_at
+		FROM bank.users`
+
+// scanUser scans a user row selected with userSelect
+func scanUser(row rowScanner) (*models.User, error) {
 	user := &models.User{}
-	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
-		FROM bank.users
-		WHERE email = $1`
-	err := r.db.QueryRow(query, email).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	var verifiedAt, verificationSentAt, totpEnabledAt, frozenAt, closedAt sql.NullTime
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PendingEmail,
+		&user.PasswordHash,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&verifiedAt,
+		&verificationSentAt,
+		&user.TOTPSecret,
+		&totpEnabledAt,
+		&user.TOTPLastStep,
+		&frozenAt,
+		&user.FullName,
+		&user.Phone,
+		&closedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	if verificationSentAt.Valid {
+		user.EmailVerificationSentAt = &verificationSentAt.Time
+	}
+	if totpEnabledAt.Valid {
+		user.TOTPEnabledAt = &totpEnabledAt.Time
+	}
+	user.TwoFactorEnabled = user.TOTPEnabledAt != nil
+	if frozenAt.Valid {
+		user.FrozenAt = &frozenAt.Time
+	}
+	if closedAt.Valid {
+		user.ClosedAt = &closedAt.Time
+	}
+	return user, nil
+}
+
+// findUser scans a single user row
+func (r *Repository) findUser(row *sql.Row) (*models.User, error) {
+	user, err := scanUser(row)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user not found")
 	}
@@ -51,143 +115,338 @@ func (r *Repository) FindUserByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
+// FindUserByEmail retrieves a user by email
+func (r *Repository) FindUserByEmail(email string) (*models.User, error) {
+	return r.findUser(r.db.QueryRow(userSelect+` WHERE email = $1`, email))
+}
+
 // FindUserByID retrieves a user by ID
 func (r *Repository) FindUserByID(userID int64) (*models.User, error) {
-	user := &models.User{}
+	return r.findUser(r.db.QueryRow(userSelect+` WHERE id = $1`, userID))
+}
+
+// UpdateUserProfile stores the profile fields of a user other than the email address,
+// which only changes through SetPendingEmail and ConfirmEmailChange
+func (r *Repository) UpdateUserProfile(user *models.User) error {
 	query := `
-		SELECT id, username, email, password_hash, created_at, updated_at
-		FROM bank.users
-		WHERE id = $1`
-	err := r.db.QueryRow(query, userID).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+		UPDATE bank.users
+		SET username = $1,
+			phone = NULLIF($2, ''),
+			full_name = NULLIF($3, ''),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND closed_at IS NULL
+		RETURNING updated_at`
+	err := r.db.QueryRow(query, user.Username, user.Phone, user.FullName, user.ID).Scan(&user.UpdatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return fmt.Errorf("user not found")
+	case isUniqueViolation(err, "users_username_key"):
+		return fmt.Errorf("username already taken")
+	case isUniqueViolation(err, "users_phone_idx"):
+		return fmt.Errorf("phone already registered")
+	case err != nil:
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	return nil
+}
+
+// SetPendingEmail records a requested new email address of a user. The current address
+// stays in use until the new one is verified with ConfirmEmailChange.
+func (r *Repository) SetPendingEmail(user *models.User, email string) error {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM bank.users WHERE email = $1)`, email).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("email already registered")
+	}
+
+	query := `
+		UPDATE bank.users
+		SET pending_email = $1,
+			email_verification_sent_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND closed_at IS NULL
+		RETURNING updated_at`
+	err = r.db.QueryRow(query, email, user.ID).Scan(&user.UpdatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return fmt.Errorf("user not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find user: %w", err)
+		return fmt.Errorf("failed to set pending email: %w", err)
 	}
-	return user, nil
+	user.PendingEmail = email
+	user.EmailVerificationSentAt = nil
+	return nil
 }
 
-// CreateAccount creates a new account in the database
-func (r *Repository) CreateAccount(account *models.Account) error {
+// ConfirmEmailChange replaces the email address of a user with their verified pending address
+func (r *Repository) ConfirmEmailChange(userID int64, email string) error {
 	query := `
-		INSERT INTO bank.accounts (user_id, balance, currency, created_at, updated_at)
-		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, created_at, updated_at`
-	err := r.db.QueryRow(query, account.UserID, account.Balance, account.Currency).
-		Scan(&account.ID, &account.CreatedAt, &account.UpdatedAt)
+		UPDATE bank.users
+		SET email = pending_email,
+			pending_email = NULL,
+			email_verified_at = CURRENT_TIMESTAMP,
+			email_verification_sent_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND pending_email = $2 AND closed_at IS NULL`
+	result, err := r.db.Exec(query, userID, email)
+	if isUniqueViolation(err, "users_email_key") {
+		return fmt.Errorf("email already registered")
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create account: %w", err)
+		return fmt.Errorf("failed to change email: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to change email: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("invalid verification token")
 	}
 	return nil
 }
 
-// FindAccountByID retrieves the user_id for an account by its ID
-func (r *Repository) FindAccountByID(accountID int64) (int64, error) {
-	var userID int64
-	query := `SELECT user_id FROM bank.accounts WHERE id = $1`
-	err := r.db.QueryRow(query, accountID).Scan(&userID)
+// CloseUser closes a user who holds no funds and owes nothing. Personal data is anonymised,
+// credentials and sessions are removed and cards and accounts are closed; accounts,
+// credits and transactions are retained.
+func (r *Repository) CloseUser(ctx context.Context, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	var closedAt sql.NullTime
+	err = tx.QueryRow(`SELECT email, closed_at FROM bank.users WHERE id = $1 FOR UPDATE`, userID).Scan(&email, &closedAt)
 	if err == sql.ErrNoRows {
-		return 0, fmt.Errorf("account not found")
+		return fmt.Errorf("user not found")
 	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to find account: %w", err)
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+	if closedAt.Valid {
+		return fmt.Errorf("user already closed")
 	}
-	return userID, nil
-}
 
-// CreateCard creates a new card in the database
-func (r *Repository) CreateCard(card *models.Card) error {
-	query := `
-		INSERT INTO bank.cards (
-			account_id,
-			card_number,
-			expiry_date,
-			cvv_hash,
-			hmac,
-			created_at,
-			updated_at
-		)
-		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, created_at, updated_at`
-	err := r.db.QueryRow(
-		query,
-		card.AccountID,
-		card.CardNumber,
-		card.ExpiryDate,
-		card.CVV,
-		card.HMAC,
-	).Scan(&card.ID, &card.CreatedAt, &card.UpdatedAt)
+	// Lock the accounts so no funds arrive while the user is being closed
+	rows, err := tx.Query(`SELECT balance FROM bank.accounts WHERE user_id = $1 FOR UPDATE`, userID)
 	if err != nil {
-		return fmt.Errorf("failed to create card: %w", err)
+		return fmt.Errorf("failed to lock accounts: %w", err)
+	}
+	funded := false
+	for rows.Next() {
+		var balance float64
+		if err := rows.Scan(&balance); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan account balance: %w", err)
+		}
+		if balance != 0 {
+			funded = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate accounts: %w", err)
+	}
+	if funded {
+		return fmt.Errorf("accounts have a non-zero balance")
+	}
+	var openCredits bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM bank.credits c
+			JOIN bank.payment_schedules p ON p.credit_id = c.id
+			WHERE c.user_id = $1 AND NOT p.paid
+		)`, userID).Scan(&openCredits)
+	if err != nil {
+		return fmt.Errorf("failed to check open credits: %w", err)
+	}
+	if openCredits {
+		return fmt.Errorf("user has open credits")
+	}
+
+	if err := revokeTokenFamilies(tx, `user_id = $1`, userID); err != nil {
+		return err
+	}
+	cleanup := []string{
+		`DELETE FROM bank.sessions WHERE user_id = $1`,
+		`DELETE FROM bank.recovery_codes WHERE user_id = $1`,
+		`DELETE FROM bank.password_reset_tokens WHERE user_id = $1`,
+		`UPDATE bank.api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`,
+		`UPDATE bank.cards SET status = 'closed', updated_at = CURRENT_TIMESTAMP
+		WHERE account_id IN (SELECT id FROM bank.accounts WHERE user_id = $1) AND status <> 'closed'`,
+		`UPDATE bank.accounts SET status = 'closed', updated_at = CURRENT_TIMESTAMP WHERE user_id = $1`,
+	}
+	for _, query := range cleanup {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to close user: %w", err)
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM bank.login_attempts WHERE scope = $1 AND key = $2`, models.LoginScopeEmail, strings.ToLower(email))
+	if err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+
+	// The password hash is not a valid bcrypt hash, so no password matches it
+	_, err = tx.Exec(`
+		UPDATE bank.users
+		SET username = 'closed-' || id,
+			email = 'closed-' || id || '@invalid',
+			pending_email = NULL,
+			password_hash = '!',
+			full_name = NULL,
+			phone = NULL,
+			totp_secret = NULL,
+			totp_enabled_at = NULL,
+			closed_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to anonymise user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	return nil
 }
 
-// CreateTransaction creates a new transaction and updates account balance
-func (r *Repository) CreateTransaction(tx *sql.Tx, transaction *models.Transaction) error {
-	// Insert transaction
-	query := `
-		INSERT INTO bank.transactions (
-			account_id,
-			amount,
-			type,
-			description,
-			created_at,
-			updated_at
-		)
-		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, created_at, updated_at`
-	err := tx.QueryRow(
-		query,
-		transaction.AccountID,
-		transaction.Amount,
-		transaction.Type,
-		transaction.Description,
-	).Scan(&transaction.ID, &transaction.CreatedAt, &transaction.UpdatedAt)
+// SearchUsers lists users whose email or username contains query, or whose ID equals it
+func (r *Repository) SearchUsers(query string, limit, offset int) ([]*models.User, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := r.db.Query(userSelect+`
+		WHERE $1 = '' OR email ILIKE $2 OR username ILIKE $2 OR id::text = $1
+		ORDER BY id
+		LIMIT $3 OFFSET $4`, query, pattern, limit, offset)
 	if err != nil {
-		return fmt.Errorf("failed to create transaction: %w", err)
+		return nil, fmt.Errorf("failed to search users: %w", err)
 	}
+	defer rows.Close()
 
-	// Update account balance
-	updateQuery := `
-		UPDATE bank.accounts
-		SET balance = balance + $1,
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return users, nil
+}
+
+// SetUserRole changes the role of a user
+func (r *Repository) SetUserRole(userID int64, role string) error {
+	query := `
+		UPDATE bank.users
+		SET role = $1,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $2`
-	_, err = tx.Exec(updateQuery, transaction.Amount, transaction.AccountID)
+	result, err := r.db.Exec(query, role, userID)
 	if err != nil {
-		return fmt.Errorf("failed to update account balance: %w", err)
+		return fmt.Errorf("failed to update user role: %w", err)
 	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
 
+// SetUserFrozen freezes or unfreezes a user
+func (r *Repository) SetUserFrozen(userID int64, frozen bool) error {
+	query := `
+		UPDATE bank.users
+		SET frozen_at = CASE WHEN $1 THEN COALESCE(frozen_at, CURRENT_TIMESTAMP) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+	result, err := r.db.Exec(query, frozen, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user freeze: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update user freeze: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("user not found")
+	}
 	return nil
 }
 
-// GetAccountBalance retrieves the current balance of an account
-func (r *Repository) GetAccountBalance(accountID int64) (float64, error) {
-	var balance float64
-	query := `SELECT balance FROM bank.accounts WHERE id = $1`
-	err := r.db.QueryRow(query, accountID).Scan(&balance)
-	if err == sql.ErrNoRows {
-		return 0, fmt.Errorf("account not found")
+// MarkEmailVerified records that a user verified their email address
+func (r *Repository) MarkEmailVerified(userID int64, email string) error {
+	query := `
+		UPDATE bank.users
+		SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND email = $2`
+	result, err := r.db.Exec(query, userID, email)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
 	}
+	updated, err := result.RowsAffected()
 	if err != nil {
-		return 0, fmt.Errorf("failed to get account balance: %w", err)
+		return fmt.Errorf("failed to verify email: %w", err)
 	}
-	return balance, nil
+	if updated == 0 {
+		return fmt.Errorf("invalid verification token")
+	}
+	return nil
 }
 
-// Deposit adds funds to an account
-func (r *Repository) Deposit(ctx context.Context, transaction *models.Transaction) error {
+// UpdateUserPassword replaces the password hash of a user
+func (r *Repository) UpdateUserPassword(userID int64, passwordHash string) error {
+	return updateUserPassword(r.db, userID, passwordHash)
+}
+
+func updateUserPassword(db execer, userID int64, passwordHash string) error {
+	query := `
+		UPDATE bank.users
+		SET password_hash = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+	_, err := db.Exec(query, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordResetToken stores a password reset token, invalidating the user's earlier unused tokens
+func (r *Repository) CreatePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	if err := r.CreateTransaction(tx, transaction); err != nil {
-		return err
+	_, err = tx.Exec(`
+		UPDATE bank.password_reset_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND used_at IS NULL`, token.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate password reset tokens: %w", err)
+	}
+
+	query := `
+		INSERT INTO bank.password_reset_tokens (user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		RETURNING id, created_at`
+	err = tx.QueryRow(query, token.UserID, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create password reset token: %w", err)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -196,36 +455,96 @@ func (r *Repository) Deposit(ctx context.Context, transaction *models.Transactio
 	return nil
 }
 
-// Withdraw removes funds from an account
-func (r *Repository) Withdraw(ctx context.Context, transaction *models.Transaction) error {
+// ResetPassword consumes an unused, unexpired password reset token and sets the password
+// hash returned by hash for the token's user. It returns the user ID.
+func (r *Repository) ResetPassword(ctx context.Context, tokenHash string, hash func(userID int64) (string, error)) (int64, error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	if err := r.CreateTransaction(tx, transaction); err != nil {
-		return err
+	var id, userID int64
+	err = tx.QueryRow(`
+		SELECT id, user_id
+		FROM bank.password_reset_tokens
+		WHERE token_hash = $1
+		AND used_at IS NULL
+		AND expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE`, tokenHash).Scan(&id, &userID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("invalid or expired reset token")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find password reset token: %w", err)
+	}
+
+	passwordHash, err := hash(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE bank.password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark password reset token used: %w", err)
+	}
+	if err := updateUserPassword(tx, userID, passwordHash); err != nil {
+		return 0, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// SetPendingTOTPSecret stores the encrypted TOTP secret of an enrollment that is not yet confirmed
+func (r *Repository) SetPendingTOTPSecret(userID int64, secret string) error {
+	query := `
+		UPDATE bank.users
+		SET totp_secret = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND totp_enabled_at IS NULL`
+	result, err := r.db.Exec(query, secret, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("two-factor authentication already enabled")
 	}
 	return nil
 }
 
-// Transfer moves funds between accounts
-func (r *Repository) Transfer(ctx context.Context, withdrawal, deposit *models.Transaction) error {
+// EnableTOTP confirms a pending TOTP enrollment and replaces the user's recovery codes
+func (r *Repository) EnableTOTP(ctx context.Context, userID, step int64, recoveryCodeHashes []string) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	if err := r.CreateTransaction(tx, withdrawal); err != nil {
-		return err
+	result, err := tx.Exec(`
+		UPDATE bank.users
+		SET totp_enabled_at = CURRENT_TIMESTAMP,
+			totp_last_step = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`, step, userID)
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor authentication: %w", err)
 	}
-	if err := r.CreateTransaction(tx, deposit); err != nil {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("two-factor authentication already enabled")
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
 		return err
 	}
 
@@ -235,70 +554,505 @@ func (r *Repository) Transfer(ctx context.Context, withdrawal, deposit *models.T
 	return nil
 }
 
-// ListTransactions retrieves a list of transactions for an account
-func (r *Repository) ListTransactions(accountID int64, transactionType string, limit, offset int) ([]*models.Transaction, error) {
-	query := `
-		SELECT id, account_id, amount, type, description, created_at, updated_at
-		FROM bank.transactions
-		WHERE account_id = $1`
-	args := []interface{}{accountID}
-
-	if transactionType != "" {
-		query += ` AND type = $2`
-		args = append(args, transactionType)
+// replaceRecoveryCodes deletes a user's recovery codes and stores new ones
+func replaceRecoveryCodes(db execer, userID int64, codeHashes []string) error {
+	_, err := db.Exec(`DELETE FROM bank.recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
 	}
+	for _, codeHash := range codeHashes {
+		_, err := db.Exec(`
+			INSERT INTO bank.recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, CURRENT_TIMESTAMP)`, userID, codeHash)
+		if err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+	return nil
+}
 
-	query += ` ORDER BY created_at DESC LIMIT $` + fmt.Sprintf("%d", len(args)+1) + ` OFFSET $` + fmt.Sprintf("%d", len(args)+2)
-	args = append(args, limit, offset)
-
-	rows, err := r.db.Query(query, args...)
+// DisableTOTP removes a user's TOTP secret and recovery codes
+func (r *Repository) DisableTOTP(ctx context.Context, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list transactions: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE bank.users
+		SET totp_secret = NULL,
+			totp_enabled_at = NULL,
+			totp_last_step = 0,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if err := replaceRecoveryCodes(tx, userID, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ClaimTOTPStep records a TOTP time step as used. It returns false if the step is not
+// after the last accepted one, meaning the code was replayed.
+func (r *Repository) ClaimTOTPStep(userID, step int64) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE bank.users
+		SET totp_last_step = $1
+		WHERE id = $2 AND totp_last_step < $1`, step, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+	return updated > 0, nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used. It returns false if
+// no such code exists.
+func (r *Repository) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE bank.recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return updated > 0, nil
+}
+
+// ClaimEmailVerificationSend records that a verification email is being sent, unless one
+// was sent within the given interval. It returns whether the send may proceed.
+func (r *Repository) ClaimEmailVerificationSend(userID int64, interval time.Duration) (bool, error) {
+	query := `
+		UPDATE bank.users
+		SET email_verification_sent_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		AND (email_verified_at IS NULL OR pending_email IS NOT NULL)
+		AND (email_verification_sent_at IS NULL OR email_verification_sent_at < $2)`
+	result, err := r.db.Exec(query, userID, time.Now().Add(-interval))
+	if err != nil {
+		return false, fmt.Errorf("failed to record verification email: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record verification email: %w", err)
+	}
+	return updated > 0, nil
+}
+
+// CreateAccount creates a new account in the database
+func (r *Repository) CreateAccount(account *models.Account) error {
+	query := `
+		INSERT INTO bank.accounts (user_id, number, balance, currency, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, status, created_at, updated_at`
+	err := r.db.QueryRow(query, account.UserID, account.Number, account.Balance, account.Currency).
+		Scan(&account.ID, &account.Status, &account.CreatedAt, &account.UpdatedAt)
+	if isUniqueViolation(err, "accounts_number_idx") {
+		return fmt.Errorf("account number already issued")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+	return nil
+}
+
+// FindAccountByID retrieves the user_id for an account by its ID
+func (r *Repository) FindAccountByID(accountID int64) (int64, error) {
+	var userID int64
+	query := `SELECT user_id FROM bank.accounts WHERE id = $1`
+	err := r.db.QueryRow(query, accountID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find account: %w", err)
+	}
+	return userID, nil
+}
+
+// FindAccountIDByNumber retrieves the ID of an account by its account number
+func (r *Repository) FindAccountIDByNumber(number string) (int64, error) {
+	var accountID int64
+	query := `SELECT id FROM bank.accounts WHERE number = $1`
+	err := r.db.QueryRow(query, number).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find account: %w", err)
+	}
+	return accountID, nil
+}
+
+// FindPrimaryAccount retrieves the oldest active account of a user in a currency, which
+// receives transfers addressed to the user rather than to an account
+func (r *Repository) FindPrimaryAccount(userID int64, currency string) (*models.Account, error) {
+	query := accountSelect + ` WHERE a.user_id = $1 AND a.currency = $2 AND a.status = $3 ORDER BY a.id LIMIT 1`
+	account, err := scanAccount(r.db.QueryRow(query, userID, currency, models.AccountStatusActive))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find account: %w", err)
+	}
+	return account, nil
+}
+
+// ListAccountsWithoutNumber retrieves accounts opened before account numbers were introduced
+func (r *Repository) ListAccountsWithoutNumber(afterID int64, limit int) ([]*models.Account, error) {
+	rows, err := r.db.Query(accountSelect+` WHERE a.number IS NULL AND a.id > $1 ORDER BY a.id LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts without number: %w", err)
 	}
 	defer rows.Close()
 
-	var transactions []*models.Transaction
+	var accounts []*models.Account
 	for rows.Next() {
-		tx := &models.Transaction{}
-		err := rows.Scan(
-			&tx.ID,
-			&tx.AccountID,
-			&tx.Amount,
-			&tx.Type,
-			&tx.Description,
-			&tx.CreatedAt,
-			&tx.UpdatedAt,
-		)
+		account, err := scanAccount(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+			return nil, fmt.Errorf("failed to scan account: %w", err)
 		}
-		transactions = append(transactions, tx)
+		accounts = append(accounts, account)
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating transactions: %w", err)
+		return nil, fmt.Errorf("error iterating accounts: %w", err)
 	}
 
-	return transactions, nil
+	return accounts, nil
 }
 
-// ListCards retrieves a list of cards for a user or specific account
-func (r *Repository) ListCards(userID, accountID int64, limit, offset int) ([]*models.Card, error) {
+// SetAccountNumber assigns an account number to an account that has none
+func (r *Repository) SetAccountNumber(accountID int64, number string) error {
 	query := `
-		SELECT c.id, c.account_id, c.card_number, c.expiry_date, c.cvv_hash, c.hmac, c.created_at, c.updated_at
-		FROM bank.cards c
-		JOIN bank.accounts a ON c.account_id = a.id
-		WHERE a.user_id = $1`
-	args := []interface{}{userID}
+		UPDATE bank.accounts
+		SET number = $1
+		WHERE id = $2 AND number IS NULL`
+	_, err := r.db.Exec(query, number, accountID)
+	if isUniqueViolation(err, "accounts_number_idx") {
+		return fmt.Errorf("account number already issued")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set account number: %w", err)
+	}
+	return nil
+}
 
-	if accountID != 0 {
-		query += ` AND c.account_id = $2`
-		args = append(args, accountID)
+// CreateCard creates a new card in the database
+func (r *Repository) CreateCard(card *models.Card) error {
+	return r.createCard(r.db, card)
+}
+
+func (r *Repository) createCard(db queryRower, card *models.Card) error {
+	if card.Status == "" {
+		card.Status = models.CardStatusActive
+	}
+	if card.CardType == "" {
+		card.CardType = models.CardTypePhysical
+	}
+	query := `
+		INSERT INTO bank.cards (
+			account_id,
+			card_number,
+			expiry_date,
+			cvv_hash,
+			hmac,
+			status,
+			expires_on,
+			reissued_from,
+			payment_system,
+			pan_fingerprint,
+			masked_pan,
+			card_type,
+			spend_cap,
+			total_spent,
+			created_at,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at`
+	err := db.QueryRow(
+		query,
+		card.AccountID,
+		card.CardNumber,
+		card.ExpiryDate,
+		card.CVV,
+		card.HMAC,
+		card.Status,
+		card.ExpiresOn,
+		card.ReissuedFrom,
+		card.PaymentSystem,
+		card.PANFingerprint,
+		card.MaskedPAN,
+		card.CardType,
+		card.SpendCap,
+		card.TotalSpent,
+	).Scan(&card.ID, &card.CreatedAt, &card.UpdatedAt)
+	if isUniqueViolation(err, "cards_pan_fingerprint_idx") {
+		return fmt.Errorf("card number already issued")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create card: %w", err)
 	}
+	return nil
+}
 
-	query += ` ORDER BY c.created_at DESC LIMIT $` + fmt.Sprintf("%d", len(args)+1) + ` OFFSET $` + fmt.Sprintf("%d", len(args)+2)
-	args = append(args, limit, offset)
+// isUniqueViolation reports whether err violates the named unique index or constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// cardSelect selects all card columns, with encrypted fields as stored
+const cardSelect = `
+		SELECT
+			c.id,
+			c.account_id,
+			c.card_number,
+			c.expiry_date,
+			c.cvv_hash,
+			c.hmac,
+			c.status,
+			c.expires_on,
+			c.reissued_from,
+			c.payment_system,
+			COALESCE(c.pan_fingerprint, ''),
+			COALESCE(c.masked_pan, ''),
+			c.card_type,
+			c.spend_cap,
+			c.total_spent,
+			COALESCE(c.pin_hash, ''),
+			c.created_at,
+			c.updated_at
+		FROM bank.cards c`
+
+// scanCard scans a row selected with cardSelect
+func scanCard(row rowScanner) (*models.Card, error) {
+	card := &models.Card{}
+	var expiresOn sql.NullTime
+	var reissuedFrom sql.NullInt64
+	var spendCap sql.NullFloat64
+	err := row.Scan(
+		&card.ID,
+		&card.AccountID,
+		&card.CardNumber,
+		&card.ExpiryDate,
+		&card.CVV,
+		&card.HMAC,
+		&card.Status,
+		&expiresOn,
+		&reissuedFrom,
+		&card.PaymentSystem,
+		&card.PANFingerprint,
+		&card.MaskedPAN,
+		&card.CardType,
+		&spendCap,
+		&card.TotalSpent,
+		&card.PINHash,
+		&card.CreatedAt,
+		&card.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresOn.Valid {
+		card.ExpiresOn = &expiresOn.Time
+	}
+	if reissuedFrom.Valid {
+		card.ReissuedFrom = &reissuedFrom.Int64
+	}
+	if spendCap.Valid {
+		card.SpendCap = &spendCap.Float64
+	}
+	card.PINSet = card.PINHash != ""
+	return card, nil
+}
+
+// findCard scans a single card row
+func (r *Repository) findCard(row *sql.Row) (*models.Card, error) {
+	card, err := scanCard(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("card not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find card: %w", err)
+	}
+	return card, nil
+}
+
+// FindCardByID retrieves a card with encrypted fields by its ID
+func (r *Repository) FindCardByID(cardID int64) (*models.Card, error) {
+	return r.findCard(r.db.QueryRow(cardSelect+` WHERE c.id = $1`, cardID))
+}
+
+// UpdateCardStatus changes the status of a card if it still has the expected status
+func (r *Repository) UpdateCardStatus(cardID int64, fromStatus, toStatus string) error {
+	query := `
+		UPDATE bank.cards
+		SET status = $1,
+			pin_attempts = CASE WHEN $1 = 'active' THEN 0 ELSE pin_attempts END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3`
+	result, err := r.db.Exec(query, toStatus, cardID, fromStatus)
+	if err != nil {
+		return fmt.Errorf("failed to update card status: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update card status: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("card status changed concurrently")
+	}
+	return nil
+}
+
+// SetCardPIN stores the PIN hash of a card and resets its wrong PIN counter. A card blocked
+// by wrong PINs becomes active again.
+func (r *Repository) SetCardPIN(cardID int64, pinHash string) error {
+	query := `
+		UPDATE bank.cards
+		SET pin_hash = $1,
+			pin_attempts = 0,
+			status = CASE WHEN status = $2 THEN $3 ELSE status END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`
+	_, err := r.db.Exec(query, pinHash, models.CardStatusPINBlocked, models.CardStatusActive, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to set card PIN: %w", err)
+	}
+	return nil
+}
+
+// RecordCardPINFailure increments the wrong PIN counter of a card, blocking the card once
+// maxAttempts is reached. It returns whether the card is now blocked.
+func (r *Repository) RecordCardPINFailure(cardID int64, maxAttempts int) (bool, error) {
+	query := `
+		UPDATE bank.cards
+		SET pin_attempts = pin_attempts + 1,
+			status = CASE WHEN pin_attempts + 1 >= $1 AND status = $2 THEN $3 ELSE status END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+		RETURNING status`
+	var status string
+	err := r.db.QueryRow(query, maxAttempts, models.CardStatusActive, models.CardStatusPINBlocked, cardID).Scan(&status)
+	if err != nil {
+		return false, fmt.Errorf("failed to record wrong PIN attempt: %w", err)
+	}
+	return status == models.CardStatusPINBlocked, nil
+}
+
+// ResetCardPINAttempts clears the wrong PIN counter of a card after a correct PIN
+func (r *Repository) ResetCardPINAttempts(cardID int64) error {
+	_, err := r.db.Exec(`UPDATE bank.cards SET pin_attempts = 0 WHERE id = $1 AND pin_attempts <> 0`, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to reset wrong PIN attempts: %w", err)
+	}
+	return nil
+}
+
+// ReissueCard closes a card and creates its replacement in a single transaction
+func (r *Repository) ReissueCard(ctx context.Context, oldCardID int64, newCard *models.Card) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	var totalSpent float64
+	err = tx.QueryRow(`SELECT status, total_spent FROM bank.cards WHERE id = $1 FOR UPDATE`, oldCardID).Scan(&status, &totalSpent)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("card not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find card: %w", err)
+	}
+	if status == models.CardStatusClosed {
+		return fmt.Errorf("closed cards cannot be reissued")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bank.cards
+		SET status = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, models.CardStatusClosed, oldCardID)
+	if err != nil {
+		return fmt.Errorf("failed to close card: %w", err)
+	}
 
+	// The replacement card keeps the spending counted against the old card's spend cap
+	newCard.ReissuedFrom = &oldCardID
+	newCard.TotalSpent = totalSpent
+	if err := r.createCard(tx, newCard); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListCardsWithoutExpiry retrieves open cards whose plaintext expiry date has not been recorded yet
+func (r *Repository) ListCardsWithoutExpiry(afterID int64, limit int) ([]*models.Card, error) {
+	query := cardSelect + `
+		WHERE c.expires_on IS NULL
+		AND c.status NOT IN ('closed', 'expired')
+		AND c.id > $1
+		ORDER BY c.id
+		LIMIT $2`
+	return r.listCards(query, afterID, limit)
+}
+
+// SetCardExpiresOn records the last day a card is valid
+func (r *Repository) SetCardExpiresOn(cardID int64, expiresOn time.Time) error {
+	query := `
+		UPDATE bank.cards
+		SET expires_on = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+	_, err := r.db.Exec(query, expiresOn, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to set card expiry: %w", err)
+	}
+	return nil
+}
+
+// ExpireCards marks cards past their expiry date as expired
+func (r *Repository) ExpireCards() (int64, error) {
+	query := `
+		UPDATE bank.cards
+		SET status = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE expires_on < CURRENT_DATE
+		AND status NOT IN ('closed', 'expired')`
+	result, err := r.db.Exec(query, models.CardStatusExpired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire cards: %w", err)
+	}
+	expired, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired cards: %w", err)
+	}
+	return expired, nil
+}
+
+// listCards runs a query selecting cards with cardSelect
+func (r *Repository) listCards(query string, args ...interface{}) ([]*models.Card, error) {
 	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list cards: %w", err)
@@ -307,17 +1061,7 @@ func (r *Repository) ListCards(userID, accountID int64, limit, offset int) ([]*m
 
 	var cards []*models.Card
 	for rows.Next() {
-		card := &models.Card{}
-		err := rows.Scan(
-			&card.ID,
-			&card.AccountID,
-			&card.CardNumber,
-			&card.ExpiryDate,
-			&card.CVV,
-			&card.HMAC,
-			&card.CreatedAt,
-			&card.UpdatedAt,
-		)
+		card, err := scanCard(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan card: %w", err)
 		}
@@ -331,291 +1075,2606 @@ func (r *Repository) ListCards(userID, accountID int64, limit, offset int) ([]*m
 	return cards, nil
 }
 
-// CreateCredit creates a new credit in the database
-func (r *Repository) CreateCredit(credit *models.Credit) error {
+// CreateTransaction creates a new transaction and updates account balance
+func (r *Repository) CreateTransaction(tx *sql.Tx, transaction *models.Transaction) error {
+	// Insert transaction
 	query := `
-		INSERT INTO bank.credits (
-			user_id,
+		INSERT INTO bank.transactions (
 			account_id,
 			amount,
-			interest_rate,
-			term_months,
-			hmac,
+			type,
+			description,
+			reversal_of,
 			created_at,
 			updated_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 		RETURNING id, created_at, updated_at`
-	err := r.db.QueryRow(
+	err := tx.QueryRow(
 		query,
-		credit.UserID,
-		credit.AccountID,
-		credit.Amount,
-		credit.InterestRate,
-		credit.TermMonths,
-		credit.HMAC,
-	).Scan(&credit.ID, &credit.CreatedAt, &credit.UpdatedAt)
+		transaction.AccountID,
+		transaction.Amount,
+		transaction.Type,
+		transaction.Description,
+		transaction.ReversalOf,
+	).Scan(&transaction.ID, &transaction.CreatedAt, &transaction.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create transaction: %w", err)
+	}
+	transaction.Status = models.TransactionStatusPosted
+	if transaction.ReversalOf != nil {
+		transaction.Status = models.TransactionStatusReversal
+	}
+
+	// Update account balance
+	updateQuery := `
+		UPDATE bank.accounts
+		SET balance = balance + $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+	_, err = tx.Exec(updateQuery, transaction.Amount, transaction.AccountID)
+	if err != nil {
+		return fmt.Errorf("failed to update account balance: %w", err)
+	}
+
+	return nil
+}
+
+// GetAccountBalance retrieves the current balance of an account
+func (r *Repository) GetAccountBalance(accountID int64) (float64, error) {
+	var balance float64
+	query := `SELECT balance FROM bank.accounts WHERE id = $1`
+	err := r.db.QueryRow(query, accountID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get account balance: %w", err)
+	}
+	return balance, nil
+}
+
+// Deposit adds funds to an active account
+func (r *Repository) Deposit(ctx context.Context, transaction *models.Transaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockActiveAccount(tx, transaction.AccountID); err != nil {
+		return err
+	}
+	if err := r.CreateTransaction(tx, transaction); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Withdraw removes funds from an active account if enough are available
+func (r *Repository) Withdraw(ctx context.Context, transaction *models.Transaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	available, err := r.lockAvailableBalance(tx, transaction.AccountID)
+	if err != nil {
+		return err
+	}
+	if available < -transaction.Amount {
+		return fmt.Errorf("insufficient funds")
+	}
+	if err := r.CreateTransaction(tx, transaction); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Transfer moves funds between active accounts if enough are available on the source account
+func (r *Repository) Transfer(ctx context.Context, withdrawal, deposit *models.Transaction) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockActiveAccounts(tx, withdrawal.AccountID, deposit.AccountID); err != nil {
+		return err
+	}
+	available, err := r.lockAvailableBalance(tx, withdrawal.AccountID)
+	if err != nil {
+		return err
+	}
+	if available < -withdrawal.Amount {
+		return fmt.Errorf("insufficient funds")
+	}
+	if err := r.CreateTransaction(tx, withdrawal); err != nil {
+		return err
+	}
+	if err := r.CreateTransaction(tx, deposit); err != nil {
+		return err
+	}
+	if err := r.linkTransactions(tx, withdrawal, deposit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// linkTransactions marks two transactions as the legs of the same transfer
+func (r *Repository) linkTransactions(tx *sql.Tx, first, second *models.Transaction) error {
+	query := `
+		UPDATE bank.transactions
+		SET related_id = CASE id WHEN $1 THEN $2 ELSE $1 END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id IN ($1, $2)`
+	_, err := tx.Exec(query, first.ID, second.ID)
+	if err != nil {
+		return fmt.Errorf("failed to link transactions: %w", err)
+	}
+	first.RelatedID = &second.ID
+	second.RelatedID = &first.ID
+	return nil
+}
+
+// FindTransactionByID retrieves a transaction by its ID
+func (r *Repository) FindTransactionByID(transactionID int64) (*models.Transaction, error) {
+	return r.findTransaction(r.db.QueryRow(transactionSelect+` WHERE t.id = $1`, transactionID))
+}
+
+// findTransactionForUpdate retrieves a transaction by its ID and locks its row
+func (r *Repository) findTransactionForUpdate(tx *sql.Tx, transactionID int64) (*models.Transaction, error) {
+	return r.findTransaction(tx.QueryRow(transactionSelect+` WHERE t.id = $1 FOR UPDATE OF t`, transactionID))
+}
+
+func (r *Repository) findTransaction(row *sql.Row) (*models.Transaction, error) {
+	transaction, err := scanTransaction(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find transaction: %w", err)
+	}
+	return transaction, nil
+}
+
+// ReverseTransaction creates compensating transactions for a transaction and,
+// for transfers, for its counterpart leg. Both legs are reversed atomically.
+func (r *Repository) ReverseTransaction(ctx context.Context, transactionID int64, description string) ([]*models.Transaction, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reversals, err := r.reverseTransaction(tx, transactionID, description)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return reversals, nil
+}
+
+// reverseTransaction creates the compensating transactions of ReverseTransaction inside an
+// existing transaction
+func (r *Repository) reverseTransaction(tx *sql.Tx, transactionID int64, description string) ([]*models.Transaction, error) {
+	original, err := r.findTransactionForUpdate(tx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if original.ReversalOf != nil {
+		return nil, fmt.Errorf("reversal transactions cannot be reversed")
+	}
+
+	legs := []*models.Transaction{original}
+	if original.RelatedID != nil {
+		related, err := r.findTransactionForUpdate(tx, *original.RelatedID)
+		if err != nil {
+			return nil, err
+		}
+		legs = append(legs, related)
+	}
+
+	// Money moves back through every leg's account, so all of them must still be active
+	if len(legs) == 2 {
+		err = lockActiveAccounts(tx, legs[0].AccountID, legs[1].AccountID)
+	} else {
+		err = lockActiveAccount(tx, original.AccountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reversals := make([]*models.Transaction, 0, len(legs))
+	for _, leg := range legs {
+		if leg.ReversedBy != nil {
+			return nil, fmt.Errorf("transaction already reversed")
+		}
+
+		// Reversing a credit debits the account, so the funds must still be available
+		if leg.Amount > 0 {
+			available, err := r.lockAvailableBalance(tx, leg.AccountID)
+			if err != nil {
+				return nil, err
+			}
+			if available < leg.Amount {
+				return nil, fmt.Errorf("insufficient funds to reverse transaction")
+			}
+		}
+
+		reversalOf := leg.ID
+		reversal := &models.Transaction{
+			AccountID:   leg.AccountID,
+			Amount:      -leg.Amount,
+			Type:        "reversal",
+			Description: description,
+			ReversalOf:  &reversalOf,
+		}
+		if err := r.CreateTransaction(tx, reversal); err != nil {
+			return nil, err
+		}
+		if leg.Type == "card_payment" {
+			if err := r.restoreCardPayment(tx, leg); err != nil {
+				return nil, err
+			}
+		}
+		reversals = append(reversals, reversal)
+	}
+
+	if len(reversals) == 2 {
+		if err := r.linkTransactions(tx, reversals[0], reversals[1]); err != nil {
+			return nil, err
+		}
+	}
+	return reversals, nil
+}
+
+// transactionSelect selects transactions together with their reversal status
+const transactionSelect = `
+		SELECT
+			t.id,
+			t.account_id,
+			t.amount,
+			t.type,
+			t.description,
+			t.related_id,
+			t.reversal_of,
+			rev.id,
+			CASE
+				WHEN t.reversal_of IS NOT NULL THEN 'reversal'
+				WHEN rev.id IS NOT NULL THEN 'reversed'
+				ELSE 'posted'
+			END,
+			t.created_at,
+			t.updated_at
+		FROM bank.transactions t
+		LEFT JOIN bank.transactions rev ON rev.reversal_of = t.id`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTransaction scans a row selected with transactionSelect
+func scanTransaction(row rowScanner) (*models.Transaction, error) {
+	transaction := &models.Transaction{}
+	var description sql.NullString
+	var relatedID, reversalOf, reversedBy sql.NullInt64
+	err := row.Scan(
+		&transaction.ID,
+		&transaction.AccountID,
+		&transaction.Amount,
+		&transaction.Type,
+		&description,
+		&relatedID,
+		&reversalOf,
+		&reversedBy,
+		&transaction.Status,
+		&transaction.CreatedAt,
+		&transaction.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	transaction.Description = description.String
+	if relatedID.Valid {
+		transaction.RelatedID = &relatedID.Int64
+	}
+	if reversalOf.Valid {
+		transaction.ReversalOf = &reversalOf.Int64
+	}
+	if reversedBy.Valid {
+		transaction.ReversedBy = &reversedBy.Int64
+	}
+	return transaction, nil
+}
+
+// ListTransactions retrieves a list of transactions for an account
+func (r *Repository) ListTransactions(accountID int64, transactionType string, limit, offset int) ([]*models.Transaction, error) {
+	query := transactionSelect + `
+		WHERE t.account_id = $1`
+	args := []interface{}{accountID}
+
+	if transactionType != "" {
+		query += ` AND t.type = $2`
+		args = append(args, transactionType)
+	}
+
+	query += ` ORDER BY t.created_at DESC LIMIT $` + fmt.Sprintf("%d", len(args)+1) + ` OFFSET $` + fmt.Sprintf("%d", len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []*models.Transaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// ListCards retrieves a list of cards for a user or specific account
+func (r *Repository) ListCards(userID, accountID int64, limit, offset int) ([]*models.Card, error) {
+	query := cardSelect + `
+		JOIN bank.accounts a ON c.account_id = a.id
+		WHERE a.user_id = $1`
+	args := []interface{}{userID}
+
+	if accountID != 0 {
+		query += ` AND c.account_id = $2`
+		args = append(args, accountID)
+	}
+
+	query += ` ORDER BY c.created_at DESC LIMIT $` + fmt.Sprintf("%d", len(args)+1) + ` OFFSET $` + fmt.Sprintf("%d", len(args)+2)
+	args = append(args, limit, offset)
+
+	return r.listCards(query, args...)
+}
+
+// CreateCredit creates a new credit in the database
+func (r *Repository) CreateCredit(credit *models.Credit) error {
+	query := `
+		INSERT INTO bank.credits (
+			user_id,
+			account_id,
+			amount,
+			interest_rate,
+			term_months,
+			hmac,
+			created_at,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at`
+	err := r.db.QueryRow(
+		query,
+		credit.UserID,
+		credit.AccountID,
+		credit.Amount,
+		credit.InterestRate,
+		credit.TermMonths,
+		credit.HMAC,
+	).Scan(&credit.ID, &credit.CreatedAt, &credit.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create credit: %w", err)
+	}
+	return nil
+}
+
+// FindCreditByID retrieves a credit by its ID
+func (r *Repository) FindCreditByID(creditID int64) (*models.Credit, error) {
+	credit := &models.Credit{}
+	query := `
+		SELECT id, user_id, account_id, amount, interest_rate, term_months, hmac, created_at, updated_at
+		FROM bank.credits
+		WHERE id = $1`
+	err := r.db.QueryRow(query, creditID).Scan(
+		&credit.ID,
+		&credit.UserID,
+		&credit.AccountID,
+		&credit.Amount,
+		&credit.InterestRate,
+		&credit.TermMonths,
+		&credit.HMAC,
+		&credit.CreatedAt,
+		&credit.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("credit not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find credit: %w", err)
+	}
+	return credit, nil
+}
+
+// CreatePaymentSchedule creates a new payment schedule entry
+func (r *Repository) CreatePaymentSchedule(payment *models.PaymentSchedule) error {
+	query := `
+		INSERT INTO bank.payment_schedules (
+			credit_id,
+			payment_date,
+			amount,
+			paid,
+			penalty,
+			created_at,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at`
+	err := r.db.QueryRow(
+		query,
+		payment.CreditID,
+		payment.PaymentDate,
+		payment.Amount,
+		payment.Paid,
+		payment.Penalty,
+	).Scan(&payment.ID, &payment.CreatedAt, &payment.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create payment schedule: %w", err)
+	}
+	return nil
+}
+
+// ListPaymentSchedules retrieves payment schedules for a credit
+func (r *Repository) ListPaymentSchedules(creditID int64) ([]*models.PaymentSchedule, error) {
+	query := `
+		SELECT id, credit_id, payment_date, amount, paid, penalty, created_at, updated_at
+		FROM bank.payment_schedules
+		WHERE credit_id = $1
+		ORDER BY payment_date ASC`
+	rows, err := r.db.Query(query, creditID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payment schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*models.PaymentSchedule
+	for rows.Next() {
+		payment := &models.PaymentSchedule{}
+		err := rows.Scan(
+			&payment.ID,
+			&payment.CreditID,
+			&payment.PaymentDate,
+			&payment.Amount,
+			&payment.Paid,
+			&payment.Penalty,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+	}
+	return payments, nil
+}
+
+// GetPendingPayments retrieves unpaid payments due today or earlier
+func (r *Repository) GetPendingPayments() ([]*models.PaymentSchedule, error) {
+	query := `
+		SELECT id, credit_id, payment_date, amount, paid, penalty, created_at, updated_at
+		FROM bank.payment_schedules
+		WHERE paid = FALSE AND payment_date <= $1
+		ORDER BY payment_date ASC`
+	rows, err := r.db.Query(query, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*models.PaymentSchedule
+	for rows.Next() {
+		payment := &models.PaymentSchedule{}
+		err := rows.Scan(
+			&payment.ID,
+			&payment.CreditID,
+			&payment.PaymentDate,
+			&payment.Amount,
+			&payment.Paid,
+			&payment.Penalty,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+	}
+	return payments, nil
+}
+
+// GetUpcomingPayments retrieves unpaid payments up to a specific date
+func (r *Repository) GetUpcomingPayments(userID int64, endDate time.Time) ([]*models.PaymentSchedule, error) {
+	query := `
+		SELECT ps.id, ps.credit_id, ps.payment_date, ps.amount, ps.paid, ps.penalty, ps.created_at, ps.updated_at
+		FROM bank.payment_schedules ps
+		JOIN bank.credits c ON ps.credit_id = c.id
+		WHERE c.user_id = $1
+		AND ps.paid = FALSE
+		AND ps.payment_date <= $2
+		ORDER BY ps.payment_date ASC`
+	rows, err := r.db.Query(query, userID, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upcoming payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*models.PaymentSchedule
+	for rows.Next() {
+		payment := &models.PaymentSchedule{}
+		err := rows.Scan(
+			&payment.ID,
+			&payment.CreditID,
+			&payment.PaymentDate,
+			&payment.Amount,
+			&payment.Paid,
+			&payment.Penalty,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+	}
+	return payments, nil
+}
+
+// GetUpcomingPaymentsByDate retrieves unpaid payments up to a specific date
+func (r *Repository) GetUpcomingPaymentsByDate(endDate time.Time) ([]*models.PaymentSchedule, error) {
+	query := `
+		SELECT id, credit_id, payment_date, amount, paid, penalty, created_at, updated_at
+		FROM bank.payment_schedules
+		WHERE paid = FALSE
+		AND payment_date <= $1
+		ORDER BY payment_date ASC`
+	rows, err := r.db.Query(query, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upcoming payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*models.PaymentSchedule
+	for rows.Next() {
+		payment := &models.PaymentSchedule{}
+		err := rows.Scan(
+			&payment.ID,
+			&payment.CreditID,
+			&payment.PaymentDate,
+			&payment.Amount,
+			&payment.Paid,
+			&payment.Penalty,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+	}
+	return payments, nil
+}
+
+// UpdatePaymentSchedule updates a payment schedule entry
+func (r *Repository) UpdatePaymentSchedule(payment *models.PaymentSchedule) error {
+	query := `
+		UPDATE bank.payment_schedules
+		SET amount = $1,
+			paid = $2,
+			penalty = $3,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`
+	_, err := r.db.Exec(query, payment.Amount, payment.Paid, payment.Penalty, payment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update payment schedule: %w", err)
+	}
+	return nil
+}
+
+// GetIncomeExpenseStats retrieves income and expense statistics for a user. Reversals cancel
+// the income or expense of the transaction they reverse.
+func (r *Repository) GetIncomeExpenseStats(userID int64, startDate, endDate time.Time) (income, expense float64, err error) {
+	query := `
+		SELECT
+			COALESCE(SUM(CASE
+				WHEN t.reversal_of IS NULL AND t.amount > 0 THEN t.amount
+				WHEN t.reversal_of IS NOT NULL AND t.amount < 0 THEN t.amount
+				ELSE 0
+			END), 0) as income,
+			COALESCE(SUM(CASE
+				WHEN t.reversal_of IS NULL AND t.amount < 0 THEN -t.amount
+				WHEN t.reversal_of IS NOT NULL AND t.amount > 0 THEN -t.amount
+				ELSE 0
+			END), 0) as expense
+		FROM bank.transactions t
+		JOIN bank.accounts a ON t.account_id = a.id
+		WHERE a.user_id = $1
+		AND t.created_at BETWEEN $2 AND $3
+		AND t.type IN ('deposit', 'transfer_in', 'withdrawal', 'transfer_out', 'credit_payment', 'hold_capture', 'card_payment', 'reversal')`
+	err = r.db.QueryRow(query, userID, startDate, endDate).Scan(&income, &expense)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get income/expense stats: %w", err)
+	}
+	return income, expense, nil
+}
+
+// GetTotalBalance retrieves the total balance across all user accounts
+func (r *Repository) GetTotalBalance(userID int64) (float64, error) {
+	var totalBalance float64
+	query := `
+		SELECT COALESCE(SUM(balance), 0)
+		FROM bank.accounts
+		WHERE user_id = $1`
+	err := r.db.QueryRow(query, userID).Scan(&totalBalance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total balance: %w", err)
+	}
+	return totalBalance, nil
+}
+
+// availableBalanceExpr computes the ledger balance of account a minus its active holds
+const availableBalanceExpr = `a.balance - COALESCE((
+			SELECT SUM(h.amount)
+			FROM bank.holds h
+			WHERE h.account_id = a.id
+			AND h.status = 'pending'
+			AND h.expires_at > CURRENT_TIMESTAMP
+		), 0)`
+
+// accountSelect selects accounts with their ledger and available balances
+const accountSelect = `
+		SELECT a.id, a.user_id, COALESCE(a.number, ''), a.balance, ` + availableBalanceExpr + `, a.currency, a.status, a.created_at, a.updated_at
+		FROM bank.accounts a`
+
+// scanAccount scans a row selected with accountSelect
+func scanAccount(row rowScanner) (*models.Account, error) {
+	account := &models.Account{}
+	err := row.Scan(
+		&account.ID,
+		&account.UserID,
+		&account.Number,
+		&account.Balance,
+		&account.AvailableBalance,
+		&account.Currency,
+		&account.Status,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// GetAccount retrieves an account with its ledger and available balances
+func (r *Repository) GetAccount(accountID int64) (*models.Account, error) {
+	account, err := scanAccount(r.db.QueryRow(accountSelect+` WHERE a.id = $1`, accountID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find account: %w", err)
+	}
+	return account, nil
+}
+
+// ListAccounts retrieves all accounts of a user, closed ones included
+func (r *Repository) ListAccounts(userID int64) ([]*models.Account, error) {
+	rows, err := r.db.Query(accountSelect+` WHERE a.user_id = $1 ORDER BY a.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []*models.Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// SetAccountFrozen freezes or unfreezes an account. Closed accounts cannot change status.
+func (r *Repository) SetAccountFrozen(accountID int64, frozen bool) error {
+	status := models.AccountStatusActive
+	if frozen {
+		status = models.AccountStatusFrozen
+	}
+	query := `
+		UPDATE bank.accounts
+		SET status = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status <> $3`
+	result, err := r.db.Exec(query, status, accountID, models.AccountStatusClosed)
+	if err != nil {
+		return fmt.Errorf("failed to update account status: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update account status: %w", err)
+	}
+	if updated == 0 {
+		if _, err := r.FindAccountByID(accountID); err != nil {
+			return err
+		}
+		return fmt.Errorf("account closed")
+	}
+	return nil
+}
+
+// CloseAccount closes an active account together with its cards. A remaining positive
+// balance is swept to another active account, whose ID is 0 when no sweep is wanted; the
+// sweep transactions are returned.
+func (r *Repository) CloseAccount(ctx context.Context, accountID, sweepToID int64) ([]*models.Transaction, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if sweepToID != 0 {
+		err = lockActiveAccounts(tx, accountID, sweepToID)
+	} else {
+		err = lockActiveAccount(tx, accountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pendingHolds, openCredits bool
+	err = tx.QueryRow(`
+		SELECT
+			EXISTS (
+				SELECT 1 FROM bank.holds
+				WHERE account_id = $1 AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP
+			),
+			EXISTS (
+				SELECT 1
+				FROM bank.credits c
+				JOIN bank.payment_schedules p ON p.credit_id = c.id
+				WHERE c.account_id = $1 AND NOT p.paid
+			)`, accountID).Scan(&pendingHolds, &openCredits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check account obligations: %w", err)
+	}
+	if pendingHolds {
+		return nil, fmt.Errorf("account has pending holds")
+	}
+	if openCredits {
+		return nil, fmt.Errorf("account has open credits")
+	}
+
+	var balance float64
+	if err := tx.QueryRow(`SELECT balance FROM bank.accounts WHERE id = $1`, accountID).Scan(&balance); err != nil {
+		return nil, fmt.Errorf("failed to get account balance: %w", err)
+	}
+	var sweep []*models.Transaction
+	switch {
+	case balance < 0:
+		return nil, fmt.Errorf("account has a negative balance")
+	case balance > 0 && sweepToID == 0:
+		return nil, fmt.Errorf("account has a non-zero balance")
+	case balance > 0:
+		withdrawal := &models.Transaction{
+			AccountID:   accountID,
+			Amount:      -balance,
+			Type:        "transfer_out",
+			Description: fmt.Sprintf("Closing balance transferred to account %d", sweepToID),
+		}
+		deposit := &models.Transaction{
+			AccountID:   sweepToID,
+			Amount:      balance,
+			Type:        "transfer_in",
+			Description: fmt.Sprintf("Closing balance of account %d", accountID),
+		}
+		if err := r.CreateTransaction(tx, withdrawal); err != nil {
+			return nil, err
+		}
+		if err := r.CreateTransaction(tx, deposit); err != nil {
+			return nil, err
+		}
+		if err := r.linkTransactions(tx, withdrawal, deposit); err != nil {
+			return nil, err
+		}
+		sweep = []*models.Transaction{withdrawal, deposit}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bank.cards
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE account_id = $2 AND status <> $1`, models.CardStatusClosed, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close cards: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE bank.accounts
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, models.AccountStatusClosed, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close account: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return sweep, nil
+}
+
+// lockActiveAccount locks an account row and ensures money may move through it
+func lockActiveAccount(tx *sql.Tx, accountID int64) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM bank.accounts WHERE id = $1 FOR UPDATE`, accountID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock account: %w", err)
+	}
+	switch status {
+	case models.AccountStatusActive:
+		return nil
+	case models.AccountStatusFrozen:
+		return fmt.Errorf("account frozen")
+	default:
+		return fmt.Errorf("account closed")
+	}
+}
+
+// lockActiveAccountOwner locks the owner of an account against concurrent freezing or closure
+// and ensures they are neither frozen nor closed
+func lockActiveAccountOwner(tx *sql.Tx, accountID int64) error {
+	var frozen, closed bool
+	query := `
+		SELECT u.frozen_at IS NOT NULL, u.closed_at IS NOT NULL
+		FROM bank.users u
+		JOIN bank.accounts a ON a.user_id = u.id
+		WHERE a.id = $1
+		FOR SHARE OF u`
+	err := tx.QueryRow(query, accountID).Scan(&frozen, &closed)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock account owner: %w", err)
+	}
+	if closed {
+		return fmt.Errorf("user closed")
+	}
+	if frozen {
+		return fmt.Errorf("user frozen")
+	}
+	return nil
+}
+
+// lockActiveAccounts locks two active accounts in ID order so that concurrent movements
+// between the same accounts cannot deadlock
+func lockActiveAccounts(tx *sql.Tx, firstID, secondID int64) error {
+	if firstID > secondID {
+		firstID, secondID = secondID, firstID
+	}
+	if err := lockActiveAccount(tx, firstID); err != nil {
+		return err
+	}
+	return lockActiveAccount(tx, secondID)
+}
+
+// GetAvailableBalance retrieves the balance of an account not reserved by holds
+func (r *Repository) GetAvailableBalance(accountID int64) (float64, error) {
+	var balance float64
+	query := `SELECT ` + availableBalanceExpr + ` FROM bank.accounts a WHERE a.id = $1`
+	err := r.db.QueryRow(query, accountID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get available balance: %w", err)
+	}
+	return balance, nil
+}
+
+// lockAvailableBalance locks an active account row and returns its available balance
+func (r *Repository) lockAvailableBalance(tx *sql.Tx, accountID int64) (float64, error) {
+	if err := lockActiveAccount(tx, accountID); err != nil {
+		return 0, err
+	}
+
+	var balance float64
+	query := `SELECT ` + availableBalanceExpr + ` FROM bank.accounts a WHERE a.id = $1`
+	err := tx.QueryRow(query, accountID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get available balance: %w", err)
+	}
+	return balance, nil
+}
+
+// holdSelect selects all hold columns
+const holdSelect = `
+		SELECT id, account_id, amount, captured_amount, status, origin, card_id, description, transaction_id, expires_at, created_at, updated_at
+		FROM bank.holds`
+
+// scanHold scans a row selected with holdSelect
+func scanHold(row rowScanner) (*models.Hold, error) {
+	hold := &models.Hold{}
+	var description sql.NullString
+	var cardID, transactionID sql.NullInt64
+	err := row.Scan(
+		&hold.ID,
+		&hold.AccountID,
+		&hold.Amount,
+		&hold.CapturedAmount,
+		&hold.Status,
+		&hold.Origin,
+		&cardID,
+		&description,
+		&transactionID,
+		&hold.ExpiresAt,
+		&hold.CreatedAt,
+		&hold.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	hold.Description = description.String
+	if cardID.Valid {
+		hold.CardID = &cardID.Int64
+	}
+	if transactionID.Valid {
+		hold.TransactionID = &transactionID.Int64
+	}
+	return hold, nil
+}
+
+// createHold reserves funds on an account inside an existing transaction
+func (r *Repository) createHold(tx *sql.Tx, hold *models.Hold) error {
+	available, err := r.lockAvailableBalance(tx, hold.AccountID)
+	if err != nil {
+		return err
+	}
+	if available < hold.Amount {
+		return fmt.Errorf("insufficient funds")
+	}
+	if hold.Origin == "" {
+		hold.Origin = models.HoldOriginCustomer
+	}
+
+	query := `
+		INSERT INTO bank.holds (account_id, amount, status, origin, card_id, description, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at`
+	err = tx.QueryRow(
+		query,
+		hold.AccountID,
+		hold.Amount,
+		models.HoldStatusPending,
+		hold.Origin,
+		hold.CardID,
+		hold.Description,
+		hold.ExpiresAt,
+	).Scan(&hold.ID, &hold.CreatedAt, &hold.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create hold: %w", err)
+	}
+	hold.Status = models.HoldStatusPending
+	return nil
+}
+
+// CreateHold reserves funds on an account if enough are available
+func (r *Repository) CreateHold(ctx context.Context, hold *models.Hold) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := r.createHold(tx, hold); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// FindHoldByID retrieves a hold by its ID
+func (r *Repository) FindHoldByID(holdID int64) (*models.Hold, error) {
+	hold, err := scanHold(r.db.QueryRow(holdSelect+` WHERE id = $1`, holdID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("hold not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find hold: %w", err)
+	}
+	return hold, nil
+}
+
+// lockPendingHold locks a hold row and ensures it can still be captured or released
+func (r *Repository) lockPendingHold(tx *sql.Tx, holdID int64) (*models.Hold, error) {
+	hold, err := scanHold(tx.QueryRow(holdSelect+` WHERE id = $1 FOR UPDATE`, holdID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("hold not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find hold: %w", err)
+	}
+	if hold.Status != models.HoldStatusPending || !hold.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("hold is not pending")
+	}
+	return hold, nil
+}
+
+// CaptureHold posts a transaction for the captured amount and closes the hold.
+// Any uncaptured remainder of the hold is released.
+func (r *Repository) CaptureHold(ctx context.Context, holdID int64, amount float64, transaction *models.Transaction) (*models.Hold, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := r.captureHold(tx, holdID, amount, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return hold, nil
+}
+
+// captureHold captures a hold inside an existing transaction
+func (r *Repository) captureHold(tx *sql.Tx, holdID int64, amount float64, transaction *models.Transaction) (*models.Hold, error) {
+	hold, err := r.lockPendingHold(tx, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if amount > hold.Amount {
+		return nil, fmt.Errorf("capture amount exceeds hold amount")
+	}
+
+	transaction.AccountID = hold.AccountID
+	transaction.Amount = -amount
+	if err := r.CreateTransaction(tx, transaction); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE bank.holds
+		SET status = $1,
+			captured_amount = $2,
+			transaction_id = $3,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+		RETURNING updated_at`
+	err = tx.QueryRow(query, models.HoldStatusCaptured, amount, transaction.ID, hold.ID).Scan(&hold.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture hold: %w", err)
+	}
+	if hold.CardID != nil && amount < hold.Amount {
+		if err := r.restoreCardSpending(tx, *hold.CardID, hold.Amount-amount, hold.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	hold.Status = models.HoldStatusCaptured
+	hold.CapturedAmount = amount
+	hold.TransactionID = &transaction.ID
+	return hold, nil
+}
+
+// ReleaseHold releases a pending hold without posting a transaction
+func (r *Repository) ReleaseHold(ctx context.Context, holdID int64) (*models.Hold, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := r.releaseHold(tx, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return hold, nil
+}
+
+// releaseHold releases a hold inside an existing transaction
+func (r *Repository) releaseHold(tx *sql.Tx, holdID int64) (*models.Hold, error) {
+	hold, err := r.lockPendingHold(tx, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE bank.holds
+		SET status = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING updated_at`
+	err = tx.QueryRow(query, models.HoldStatusReleased, hold.ID).Scan(&hold.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release hold: %w", err)
+	}
+	if hold.CardID != nil {
+		if err := r.restoreCardSpending(tx, *hold.CardID, hold.Amount, hold.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	hold.Status = models.HoldStatusReleased
+	return hold, nil
+}
+
+// ExpireHolds marks pending holds past their expiry as expired and gives the amounts of
+// expired card holds back to the cards' spending counters
+func (r *Repository) ExpireHolds() (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE bank.holds
+		SET status = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE status = $2
+		AND expires_at <= CURRENT_TIMESTAMP
+		RETURNING card_id, amount, created_at`
+	rows, err := tx.Query(query, models.HoldStatusExpired, models.HoldStatusPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire holds: %w", err)
+	}
+
+	var expired int64
+	var cardHolds []*models.Hold
+	for rows.Next() {
+		var cardID sql.NullInt64
+		hold := &models.Hold{}
+		if err := rows.Scan(&cardID, &hold.Amount, &hold.CreatedAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan expired hold: %w", err)
+		}
+		expired++
+		if cardID.Valid {
+			hold.CardID = &cardID.Int64
+			cardHolds = append(cardHolds, hold)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("failed to iterate expired holds: %w", err)
+	}
+	rows.Close()
+
+	for _, hold := range cardHolds {
+		if err := r.restoreCardSpending(tx, *hold.CardID, hold.Amount, hold.CreatedAt); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return expired, nil
+}
+
+// ListHolds retrieves holds for an account, optionally filtered by status
+func (r *Repository) ListHolds(accountID int64, status string, limit, offset int) ([]*models.Hold, error) {
+	query := holdSelect + `
+		WHERE account_id = $1`
+	args := []interface{}{accountID}
+
+	if status != "" {
+		query += ` AND status = $2`
+		args = append(args, status)
+	}
+
+	query += ` ORDER BY created_at DESC LIMIT $` + fmt.Sprintf("%d", len(args)+1) + ` OFFSET $` + fmt.Sprintf("%d", len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list holds: %w", err)
+	}
+	defer rows.Close()
+
+	var holds []*models.Hold
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hold: %w", err)
+		}
+		holds = append(holds, hold)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating holds: %w", err)
+	}
+	return holds, nil
+}
+
+// FindCardByFingerprint retrieves a card with encrypted fields by its PAN fingerprint
+func (r *Repository) FindCardByFingerprint(fingerprint string) (*models.Card, error) {
+	return r.findCard(r.db.QueryRow(cardSelect+` WHERE c.pan_fingerprint = $1`, fingerprint))
+}
+
+// ListCardsWithoutLookupFields retrieves cards created before PAN fingerprints and masked PANs were recorded
+func (r *Repository) ListCardsWithoutLookupFields(afterID int64, limit int) ([]*models.Card, error) {
+	query := cardSelect + `
+		WHERE (c.pan_fingerprint IS NULL OR c.masked_pan IS NULL)
+		AND c.id > $1
+		ORDER BY c.id
+		LIMIT $2`
+	return r.listCards(query, afterID, limit)
+}
+
+// SetCardLookupFields records the PAN fingerprint and masked PAN of a card
+func (r *Repository) SetCardLookupFields(cardID int64, fingerprint, maskedPAN string) error {
+	query := `
+		UPDATE bank.cards
+		SET pan_fingerprint = $1,
+			masked_pan = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`
+	_, err := r.db.Exec(query, fingerprint, maskedPAN, cardID)
+	if isUniqueViolation(err, "cards_pan_fingerprint_idx") {
+		return fmt.Errorf("card number already issued")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set card lookup fields: %w", err)
+	}
+	return nil
+}
+
+// CreateCardAuthorization records the outcome of a card authorization request
+func (r *Repository) CreateCardAuthorization(auth *models.CardAuthorization) error {
+	return r.createCardAuthorization(r.db, auth)
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (r *Repository) createCardAuthorization(db queryRower, auth *models.CardAuthorization) error {
+	query := `
+		INSERT INTO bank.card_authorizations (
+			card_id,
+			amount,
+			currency,
+			merchant_id,
+			merchant_name,
+			mcc,
+			channel,
+			response_code,
+			auth_code,
+			transaction_id,
+			hold_id,
+			created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CURRENT_TIMESTAMP)
+		RETURNING id, created_at`
+	err := db.QueryRow(
+		query,
+		auth.CardID,
+		auth.Amount,
+		auth.Currency,
+		auth.MerchantID,
+		auth.MerchantName,
+		auth.MCC,
+		auth.Channel,
+		auth.ResponseCode,
+		auth.AuthCode,
+		auth.TransactionID,
+		auth.HoldID,
+	).Scan(&auth.ID, &auth.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record card authorization: %w", err)
+	}
+	return nil
+}
+
+// AuthorizeCardPayment debits the card's account, or places a hold on it, and
+// records the approved authorization in a single transaction
+func (r *Repository) AuthorizeCardPayment(ctx context.Context, auth *models.CardAuthorization, accountID int64, capture bool, holdExpiresAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockActiveAccountOwner(tx, accountID); err != nil {
+		return err
+	}
+	if err := r.applyCardSpending(tx, *auth.CardID, auth.Amount); err != nil {
+		return err
+	}
+	if err := r.applyCardLimits(tx, *auth.CardID, auth.Amount, auth.Channel, auth.MCC); err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("Card payment at %s", auth.MerchantName)
+	if capture {
+		available, err := r.lockAvailableBalance(tx, accountID)
+		if err != nil {
+			return err
+		}
+		if available < auth.Amount {
+			return fmt.Errorf("insufficient funds")
+		}
+
+		transaction := &models.Transaction{
+			AccountID:   accountID,
+			Amount:      -auth.Amount,
+			Type:        "card_payment",
+			Description: description,
+		}
+		if err := r.CreateTransaction(tx, transaction); err != nil {
+			return err
+		}
+		auth.TransactionID = &transaction.ID
+	} else {
+		hold := &models.Hold{
+			AccountID:   accountID,
+			Amount:      auth.Amount,
+			Origin:      models.HoldOriginCardNetwork,
+			CardID:      auth.CardID,
+			Description: description,
+			ExpiresAt:   holdExpiresAt,
+		}
+		if err := r.createHold(tx, hold); err != nil {
+			return err
+		}
+		auth.HoldID = &hold.ID
+	}
+
+	if err := r.createCardAuthorization(tx, auth); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// cardAuthorizationSelect selects all card authorization columns
+const cardAuthorizationSelect = `
+		SELECT
+			id,
+			card_id,
+			amount,
+			currency,
+			COALESCE(merchant_id, ''),
+			COALESCE(merchant_name, ''),
+			COALESCE(mcc, ''),
+			COALESCE(channel, ''),
+			response_code,
+			COALESCE(auth_code, ''),
+			transaction_id,
+			hold_id,
+			reversed_at,
+			created_at
+		FROM bank.card_authorizations`
+
+// scanCardAuthorization scans a row selected with cardAuthorizationSelect
+func scanCardAuthorization(row rowScanner) (*models.CardAuthorization, error) {
+	auth := &models.CardAuthorization{}
+	var cardID, transactionID, holdID sql.NullInt64
+	var reversedAt sql.NullTime
+	err := row.Scan(
+		&auth.ID,
+		&cardID,
+		&auth.Amount,
+		&auth.Currency,
+		&auth.MerchantID,
+		&auth.MerchantName,
+		&auth.MCC,
+		&auth.Channel,
+		&auth.ResponseCode,
+		&auth.AuthCode,
+		&transactionID,
+		&holdID,
+		&reversedAt,
+		&auth.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if cardID.Valid {
+		auth.CardID = &cardID.Int64
+	}
+	if transactionID.Valid {
+		auth.TransactionID = &transactionID.Int64
+	}
+	if holdID.Valid {
+		auth.HoldID = &holdID.Int64
+	}
+	if reversedAt.Valid {
+		auth.ReversedAt = &reversedAt.Time
+	}
+	auth.Approved = auth.ResponseCode == models.ResponseApproved
+	return auth, nil
+}
+
+// lockApprovedCardAuthorization locks an approved card authorization that was not reversed
+func (r *Repository) lockApprovedCardAuthorization(tx *sql.Tx, authID int64) (*models.CardAuthorization, error) {
+	auth, err := scanCardAuthorization(tx.QueryRow(cardAuthorizationSelect+` WHERE id = $1 FOR UPDATE`, authID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("card authorization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find card authorization: %w", err)
+	}
+	if !auth.Approved {
+		return nil, fmt.Errorf("card payment not approved")
+	}
+	if auth.ReversedAt != nil {
+		return nil, fmt.Errorf("card payment already reversed")
+	}
+	return auth, nil
+}
+
+// CaptureCardPayment settles all or part of the hold placed by a card authorization. Any
+// uncaptured remainder of the hold is released.
+func (r *Repository) CaptureCardPayment(ctx context.Context, authID int64, amount float64, transaction *models.Transaction) (*models.CardAuthorization, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	auth, err := r.lockApprovedCardAuthorization(tx, authID)
+	if err != nil {
+		return nil, err
+	}
+	if auth.TransactionID != nil || auth.HoldID == nil {
+		return nil, fmt.Errorf("card payment already captured")
+	}
+	if amount == 0 {
+		amount = auth.Amount
+	}
+	transaction.Description = fmt.Sprintf("Card payment at %s", auth.MerchantName)
+	if _, err := r.captureHold(tx, *auth.HoldID, amount, transaction); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE bank.card_authorizations SET transaction_id = $1 WHERE id = $2`, transaction.ID, auth.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record card payment capture: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	auth.TransactionID = &transaction.ID
+	return auth, nil
+}
+
+// ReverseCardPayment cancels a card authorization: a pending hold is released and a captured
+// payment is refunded with a compensating transaction
+func (r *Repository) ReverseCardPayment(ctx context.Context, authID int64, description string) (*models.CardAuthorization, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	auth, err := r.lockApprovedCardAuthorization(tx, authID)
+	if err != nil {
+		return nil, err
+	}
+	if auth.TransactionID != nil {
+		if _, err := r.reverseTransaction(tx, *auth.TransactionID, description); err != nil {
+			return nil, err
+		}
+	} else if auth.HoldID != nil {
+		if _, err := r.releaseHold(tx, *auth.HoldID); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(`
+		UPDATE bank.card_authorizations
+		SET reversed_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING reversed_at`, auth.ID).Scan(&auth.ReversedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record card payment reversal: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return auth, nil
+}
+
+// cardLimitsSelect selects all card limit columns
+const cardLimitsSelect = `
+		SELECT
+			card_id,
+			daily_limit,
+			monthly_limit,
+			per_transaction_limit,
+			online_enabled,
+			atm_enabled,
+			contactless_enabled,
+			blocked_mccs,
+			daily_spent,
+			monthly_spent,
+			updated_at
+		FROM bank.card_limits`
+
+// scanCardLimits scans a row selected with cardLimitsSelect
+func scanCardLimits(row rowScanner) (*models.CardLimits, error) {
+	limits := &models.CardLimits{}
+	var daily, monthly, perTransaction sql.NullFloat64
+	err := row.Scan(
+		&limits.CardID,
+		&daily,
+		&monthly,
+		&perTransaction,
+		&limits.OnlineEnabled,
+		&limits.ATMEnabled,
+		&limits.ContactlessEnabled,
+		pq.Array(&limits.BlockedMCCs),
+		&limits.DailySpent,
+		&limits.MonthlySpent,
+		&limits.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if daily.Valid {
+		limits.DailyLimit = &daily.Float64
+	}
+	if monthly.Valid {
+		limits.MonthlyLimit = &monthly.Float64
+	}
+	if perTransaction.Valid {
+		limits.PerTransactionLimit = &perTransaction.Float64
+	}
+	return limits, nil
+}
+
+// ensureCardLimits creates the default, unlimited limits row of a card if it has none
+func ensureCardLimits(db execer, cardID int64) error {
+	_, err := db.Exec(`
+		INSERT INTO bank.card_limits (card_id, updated_at)
+		VALUES ($1, CURRENT_TIMESTAMP)
+		ON CONFLICT (card_id) DO NOTHING`, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to create card limits: %w", err)
+	}
+	return nil
+}
+
+// GetCardLimits retrieves the limits and current usage of a card
+func (r *Repository) GetCardLimits(cardID int64) (*models.CardLimits, error) {
+	limits, err := scanCardLimits(r.db.QueryRow(cardLimitsSelect+` WHERE card_id = $1`, cardID))
+	if err == sql.ErrNoRows {
+		// Cards without a limits row have the default, unlimited limits
+		return &models.CardLimits{
+			CardID:             cardID,
+			OnlineEnabled:      true,
+			ATMEnabled:         true,
+			ContactlessEnabled: true,
+			BlockedMCCs:        []string{},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find card limits: %w", err)
+	}
+	return limits, nil
+}
+
+// SetCardLimits replaces the configurable limits of a card, keeping its usage counters
+func (r *Repository) SetCardLimits(limits *models.CardLimits) error {
+	query := `
+		INSERT INTO bank.card_limits (
+			card_id,
+			daily_limit,
+			monthly_limit,
+			per_transaction_limit,
+			online_enabled,
+			atm_enabled,
+			contactless_enabled,
+			blocked_mccs,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+		ON CONFLICT (card_id) DO UPDATE SET
+			daily_limit = EXCLUDED.daily_limit,
+			monthly_limit = EXCLUDED.monthly_limit,
+			per_transaction_limit = EXCLUDED.per_transaction_limit,
+			online_enabled = EXCLUDED.online_enabled,
+			atm_enabled = EXCLUDED.atm_enabled,
+			contactless_enabled = EXCLUDED.contactless_enabled,
+			blocked_mccs = EXCLUDED.blocked_mccs,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING daily_spent, monthly_spent, updated_at`
+	err := r.db.QueryRow(
+		query,
+		limits.CardID,
+		limits.DailyLimit,
+		limits.MonthlyLimit,
+		limits.PerTransactionLimit,
+		limits.OnlineEnabled,
+		limits.ATMEnabled,
+		limits.ContactlessEnabled,
+		pq.Array(limits.BlockedMCCs),
+	).Scan(&limits.DailySpent, &limits.MonthlySpent, &limits.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set card limits: %w", err)
+	}
+	return nil
+}
+
+// applyCardLimits checks a card debit against the card's channel, merchant category and
+// spending limits and adds it to the card's usage counters. The limits row is locked so
+// concurrent authorizations cannot overspend.
+func (r *Repository) applyCardLimits(tx *sql.Tx, cardID int64, amount float64, channel, mcc string) error {
+	if err := ensureCardLimits(tx, cardID); err != nil {
+		return err
+	}
+	limits, err := scanCardLimits(tx.QueryRow(cardLimitsSelect+` WHERE card_id = $1 FOR UPDATE`, cardID))
+	if err != nil {
+		return fmt.Errorf("failed to lock card limits: %w", err)
+	}
+
+	switch {
+	case channel == models.CardChannelOnline && !limits.OnlineEnabled,
+		channel == models.CardChannelATM && !limits.ATMEnabled,
+		channel == models.CardChannelContactless && !limits.ContactlessEnabled:
+		return fmt.Errorf("card channel disabled")
+	}
+	for _, blocked := range limits.BlockedMCCs {
+		if mcc != "" && mcc == blocked {
+			return fmt.Errorf("merchant category blocked")
+		}
+	}
+	if limits.PerTransactionLimit != nil && amount > *limits.PerTransactionLimit {
+		return fmt.Errorf("card limit exceeded")
+	}
+	if limits.DailyLimit != nil && limits.DailySpent+amount > *limits.DailyLimit {
+		return fmt.Errorf("card limit exceeded")
+	}
+	if limits.MonthlyLimit != nil && limits.MonthlySpent+amount > *limits.MonthlyLimit {
+		return fmt.Errorf("card limit exceeded")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bank.card_limits
+		SET daily_spent = daily_spent + $1,
+			monthly_spent = monthly_spent + $1
+		WHERE card_id = $2`, amount, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to update card usage: %w", err)
+	}
+	return nil
+}
+
+// applyCardSpending locks a card, checks a debit against its spend cap and adds it to the
+// card's total spending. Single-use cards, and cards reaching their spend cap, are closed.
+func (r *Repository) applyCardSpending(tx *sql.Tx, cardID int64, amount float64) error {
+	var status, cardType string
+	var spendCap sql.NullFloat64
+	var totalSpent float64
+	err := tx.QueryRow(`
+		SELECT status, card_type, spend_cap, total_spent
+		FROM bank.cards
+		WHERE id = $1
+		FOR UPDATE`, cardID).Scan(&status, &cardType, &spendCap, &totalSpent)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("card not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock card: %w", err)
+	}
+
+	// The card may have been closed by a concurrent authorization since it was verified
+	if status != models.CardStatusActive {
+		return fmt.Errorf("card is not active")
+	}
+	if spendCap.Valid && totalSpent+amount > spendCap.Float64 {
+		return fmt.Errorf("card limit exceeded")
+	}
+
+	totalSpent += amount
+	if cardType == models.CardTypeSingleUse || (spendCap.Valid && totalSpent >= spendCap.Float64) {
+		status = models.CardStatusClosed
+	}
+	_, err = tx.Exec(`
+		UPDATE bank.cards
+		SET total_spent = $1,
+			status = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`, totalSpent, status, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to update card spending: %w", err)
+	}
+	return nil
+}
+
+// restoreCardSpending gives a debit that was counted at spentAt back to a card's total spending
+// and usage counters. The daily and monthly counters are only reduced if they have not been
+// reset since the debit was counted.
+func (r *Repository) restoreCardSpending(tx *sql.Tx, cardID int64, amount float64, spentAt time.Time) error {
+	now := time.Now()
+	var daily, monthly float64
+	if !spentAt.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())) {
+		daily = amount
+	}
+	if !spentAt.Before(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())) {
+		monthly = amount
+	}
+
+	_, err := tx.Exec(`
+		UPDATE bank.card_limits
+		SET daily_spent = GREATEST(daily_spent - $1, 0),
+			monthly_spent = GREATEST(monthly_spent - $2, 0)
+		WHERE card_id = $3`, daily, monthly, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to restore card usage: %w", err)
+	}
+	// Replacement cards carry the total spending of the card they were reissued from over
+	_, err = tx.Exec(`
+		WITH RECURSIVE reissued AS (
+			SELECT id FROM bank.cards WHERE id = $2
+			UNION ALL
+			SELECT c.id FROM bank.cards c JOIN reissued ON c.reissued_from = reissued.id
+		)
+		UPDATE bank.cards
+		SET total_spent = GREATEST(total_spent - $1, 0),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (SELECT id FROM reissued)`, amount, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to restore card spending: %w", err)
+	}
+	return nil
+}
+
+// restoreCardPayment gives a reversed card payment back to the spending of the card that
+// authorized it
+func (r *Repository) restoreCardPayment(tx *sql.Tx, payment *models.Transaction) error {
+	var cardID int64
+	var authorizedAt time.Time
+	err := tx.QueryRow(`
+		SELECT card_id, created_at
+		FROM bank.card_authorizations
+		WHERE transaction_id = $1
+		AND card_id IS NOT NULL`, payment.ID).Scan(&cardID, &authorizedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find card authorization: %w", err)
+	}
+	return r.restoreCardSpending(tx, cardID, -payment.Amount, authorizedAt)
+}
+
+// ResetCardDailySpending resets the daily usage counters of all cards
+func (r *Repository) ResetCardDailySpending() (int64, error) {
+	result, err := r.db.Exec(`UPDATE bank.card_limits SET daily_spent = 0 WHERE daily_spent <> 0`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset daily card spending: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ResetCardMonthlySpending resets the monthly usage counters of all cards
+func (r *Repository) ResetCardMonthlySpending() (int64, error) {
+	result, err := r.db.Exec(`UPDATE bank.card_limits SET monthly_spent = 0 WHERE monthly_spent <> 0`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset monthly card spending: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// reencryptionJobSelect selects all re-encryption job columns
+const reencryptionJobSelect = `
+		SELECT id, key_version, status, processed, failed, COALESCE(error, ''), started_by, started_at, updated_at, finished_at
+		FROM bank.reencryption_jobs`
+
+// scanReencryptionJob scans a row selected with reencryptionJobSelect
+func scanReencryptionJob(row rowScanner) (*models.ReencryptionJob, error) {
+	job := &models.ReencryptionJob{}
+	var finishedAt sql.NullTime
+	err := row.Scan(
+		&job.ID,
+		&job.KeyVersion,
+		&job.Status,
+		&job.Processed,
+		&job.Failed,
+		&job.Error,
+		&job.StartedBy,
+		&job.StartedAt,
+		&job.UpdatedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
+
+// CreateReencryptionJob starts a re-encryption job unless one is already running. A running
+// job that has not reported progress within staleAfter is assumed to have died with its
+// instance and is marked as failed.
+func (r *Repository) CreateReencryptionJob(job *models.ReencryptionJob, staleAfter time.Duration) error {
+	_, err := r.db.Exec(`
+		UPDATE bank.reencryption_jobs
+		SET status = $1,
+			error = 'interrupted',
+			updated_at = CURRENT_TIMESTAMP,
+			finished_at = CURRENT_TIMESTAMP
+		WHERE status = $2
+		AND updated_at < $3`,
+		models.ReencryptionJobFailed, models.ReencryptionJobRunning, time.Now().Add(-staleAfter))
+	if err != nil {
+		return fmt.Errorf("failed to fail stale re-encryption jobs: %w", err)
+	}
+
+	query := `
+		INSERT INTO bank.reencryption_jobs (key_version, status, started_by, started_at, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, started_at, updated_at`
+	err = r.db.QueryRow(query, job.KeyVersion, models.ReencryptionJobRunning, job.StartedBy).
+		Scan(&job.ID, &job.StartedAt, &job.UpdatedAt)
+	if isUniqueViolation(err, "reencryption_jobs_running_idx") {
+		return fmt.Errorf("re-encryption job already running")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create re-encryption job: %w", err)
+	}
+	job.Status = models.ReencryptionJobRunning
+	return nil
+}
+
+// UpdateReencryptionJob records the progress or outcome of a re-encryption job
+func (r *Repository) UpdateReencryptionJob(job *models.ReencryptionJob) error {
+	query := `
+		UPDATE bank.reencryption_jobs
+		SET status = $1,
+			processed = $2,
+			failed = $3,
+			error = NULLIF($4, ''),
+			updated_at = CURRENT_TIMESTAMP,
+			finished_at = CASE WHEN $1 = 'running' THEN NULL ELSE CURRENT_TIMESTAMP END
+		WHERE id = $5`
+	_, err := r.db.Exec(query, job.Status, job.Processed, job.Failed, job.Error, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update re-encryption job: %w", err)
+	}
+	return nil
+}
+
+// FindReencryptionJobByID retrieves a re-encryption job by its ID
+func (r *Repository) FindReencryptionJobByID(jobID int64) (*models.ReencryptionJob, error) {
+	job, err := scanReencryptionJob(r.db.QueryRow(reencryptionJobSelect+` WHERE id = $1`, jobID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("re-encryption job not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find re-encryption job: %w", err)
+	}
+	return job, nil
+}
+
+// ListCardsNotEncryptedWith retrieves cards whose encrypted fields do not carry the given envelope prefix
+func (r *Repository) ListCardsNotEncryptedWith(prefix string, afterID int64, limit int) ([]*models.Card, error) {
+	query := cardSelect + `
+		WHERE (left(c.card_number, length($1)) <> $1 OR left(c.expiry_date, length($1)) <> $1)
+		AND c.id > $2
+		ORDER BY c.id
+		LIMIT $3`
+	return r.listCards(query, prefix, afterID, limit)
+}
+
+// UpdateCardCiphertexts replaces the encrypted fields of a card if they were not changed concurrently
+func (r *Repository) UpdateCardCiphertexts(cardID int64, oldCardNumber, oldExpiryDate, cardNumber, expiryDate string) error {
+	query := `
+		UPDATE bank.cards
+		SET card_number = $1,
+			expiry_date = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND card_number = $4 AND expiry_date = $5`
+	result, err := r.db.Exec(query, cardNumber, expiryDate, cardID, oldCardNumber, oldExpiryDate)
+	if err != nil {
+		return fmt.Errorf("failed to update card ciphertexts: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update card ciphertexts: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("card changed concurrently")
+	}
+	return nil
+}
+
+// CreateCardReveal records that a card's full details were revealed
+func (r *Repository) CreateCardReveal(reveal *models.CardReveal) error {
+	query := `
+		INSERT INTO bank.card_reveals (card_id, user_id, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING id, created_at`
+	err := r.db.QueryRow(query, reveal.CardID, reveal.UserID, reveal.IPAddress, reveal.UserAgent).
+		Scan(&reveal.ID, &reveal.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record card reveal: %w", err)
+	}
+	return nil
+}
+
+// CreateRecipientLookup records an audit entry of a transfer recipient preview
+func (r *Repository) CreateRecipientLookup(lookup *models.RecipientLookup) error {
+	query := `
+		INSERT INTO bank.recipient_lookups (user_id, recipient_type, account_id, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, created_at`
+	err := r.db.QueryRow(query, lookup.UserID, lookup.RecipientType, lookup.AccountID, lookup.IPAddress, lookup.UserAgent).
+		Scan(&lookup.ID, &lookup.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record recipient lookup: %w", err)
+	}
+	return nil
+}
+
+// CountRecipientLookups counts the transfer recipient previews of a user since a time
+func (r *Repository) CountRecipientLookups(userID int64, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM bank.recipient_lookups
+		WHERE user_id = $1 AND created_at >= $2`, userID, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recipient lookups: %w", err)
+	}
+	return count, nil
+}
+
+// CreateSession records a login session and stores the first refresh token of its family.
+// It reports whether the user agent has not been seen in an earlier session of the user.
+func (r *Repository) CreateSession(ctx context.Context, session *models.Session, token *models.RefreshToken) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var hasSessions, knownDevice bool
+	err = tx.QueryRow(`
+		SELECT
+			EXISTS (SELECT 1 FROM bank.sessions WHERE user_id = $1),
+			EXISTS (SELECT 1 FROM bank.sessions WHERE user_id = $1 AND user_agent = $2)`,
+		session.UserID, session.UserAgent,
+	).Scan(&hasSessions, &knownDevice)
+	if err != nil {
+		return false, fmt.Errorf("failed to check known devices: %w", err)
+	}
+
+	query := `
+		INSERT INTO bank.sessions (user_id, family_id, ip_address, user_agent, created_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, last_seen_at`
+	err = tx.QueryRow(query, session.UserID, session.FamilyID, session.IPAddress, session.UserAgent).
+		Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := r.createRefreshToken(tx, token); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	// The first session of a user is not a new device
+	return hasSessions && !knownDevice, nil
+}
+
+// ListSessions retrieves the sessions of a user whose token family is still live. The
+// session of the access token identified by currentJTI is marked as current.
+func (r *Repository) ListSessions(userID int64, currentJTI string) ([]*models.Session, error) {
+	query := `
+		SELECT
+			s.id,
+			s.user_id,
+			s.family_id,
+			COALESCE(s.ip_address, ''),
+			COALESCE(s.user_agent, ''),
+			s.created_at,
+			s.last_seen_at,
+			EXISTS (SELECT 1 FROM bank.refresh_tokens c WHERE c.family_id = s.family_id AND c.access_jti = $2)
+		FROM bank.sessions s
+		WHERE s.user_id = $1
+		AND EXISTS (
+			SELECT 1 FROM bank.refresh_tokens t
+			WHERE t.family_id = s.family_id
+			AND t.used_at IS NULL
+			AND t.revoked_at IS NULL
+			AND t.expires_at > CURRENT_TIMESTAMP
+		)
+		ORDER BY s.last_seen_at DESC, s.id DESC`
+	rows, err := r.db.Query(query, userID, currentJTI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		session := &models.Session{}
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.FamilyID,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes the token family of a session of a user
+func (r *Repository) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var familyID string
+	err = tx.QueryRow(`SELECT family_id FROM bank.sessions WHERE id = $1 AND user_id = $2`, sessionID, userID).Scan(&familyID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find session: %w", err)
+	}
+	if err := revokeTokenFamilies(tx, `family_id = $1`, familyID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) createRefreshToken(db queryRower, token *models.RefreshToken) error {
+	query := `
+		INSERT INTO bank.refresh_tokens (
+			user_id,
+			family_id,
+			token_hash,
+			access_jti,
+			access_expires_at,
+			expires_at,
+			amr,
+			created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+		RETURNING id, created_at`
+	err := db.QueryRow(
+		query,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.AccessJTI,
+		token.AccessExpiresAt,
+		token.ExpiresAt,
+		strings.Join(token.AMR, " "),
+	).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken exchanges the refresh token with the given hash for a successor in the
+// same family, created by issue for the token's user and family. Presenting a token that was
+// already rotated or revoked is treated as theft: the whole family is revoked and the reuse
+// is reported as an error.
+func (r *Repository) RotateRefreshToken(ctx context.Context, tokenHash, ipAddress string, issue func(userID int64, familyID string, amr []string) (*models.RefreshToken, error)) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id, userID int64
+	var familyID, amr string
+	var expiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT id, user_id, family_id, amr, expires_at, used_at, revoked_at
+		FROM bank.refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE`, tokenHash).Scan(&id, &userID, &familyID, &amr, &expiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("invalid refresh token")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find refresh token: %w", err)
+	}
+
+	if usedAt.Valid || revokedAt.Valid {
+		if err := revokeTokenFamilies(tx, `family_id = $1`, familyID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return fmt.Errorf("refresh token reuse detected")
+	}
+	if !time.Now().Before(expiresAt) {
+		return fmt.Errorf("invalid refresh token")
+	}
+
+	_, err = tx.Exec(`UPDATE bank.refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+
+	next, err := issue(userID, familyID, strings.Fields(amr))
+	if err != nil {
+		return err
+	}
+	if err := r.createRefreshToken(tx, next); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE bank.sessions
+		SET last_seen_at = CURRENT_TIMESTAMP,
+			ip_address = $1
+		WHERE family_id = $2`, ipAddress, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// revokeTokenFamilies revokes the refresh tokens matching the condition along with every
+// other token in their families, and denylists the access tokens issued with them that
+// have not yet expired
+func revokeTokenFamilies(db execer, condition string, args ...interface{}) error {
+	families := `SELECT family_id FROM bank.refresh_tokens WHERE ` + condition
+	_, err := db.Exec(`
+		INSERT INTO bank.revoked_tokens (jti, user_id, expires_at, revoked_at)
+		SELECT access_jti, user_id, access_expires_at, CURRENT_TIMESTAMP
+		FROM bank.refresh_tokens
+		WHERE family_id IN (`+families+`)
+		AND access_expires_at > CURRENT_TIMESTAMP
+		ON CONFLICT (jti) DO NOTHING`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	_, err = db.Exec(`
+		UPDATE bank.refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id IN (`+families+`)
+		AND revoked_at IS NULL`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// RevokeTokenFamilyByAccessJTI revokes the token family an access token was issued in
+func (r *Repository) RevokeTokenFamilyByAccessJTI(jti string) error {
+	return revokeTokenFamilies(r.db, `access_jti = $1`, jti)
+}
+
+// RevokeUserTokens revokes every token family of a user
+func (r *Repository) RevokeUserTokens(userID int64) error {
+	return revokeTokenFamilies(r.db, `user_id = $1`, userID)
+}
+
+// RevokeAccessToken denylists an access token until it expires
+func (r *Repository) RevokeAccessToken(jti string, userID int64, expiresAt time.Time) error {
+	query := `
+		INSERT INTO bank.revoked_tokens (jti, user_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (jti) DO NOTHING`
+	_, err := r.db.Exec(query, jti, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether an access token is denylisted
+func (r *Repository) IsTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM bank.revoked_tokens WHERE jti = $1)`, jti).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+// LoginBlockedUntil returns the latest time until which logins are blocked for an email
+// address or a client IP. It returns the zero time if neither is blocked.
+func (r *Repository) LoginBlockedUntil(email, ip string) (time.Time, error) {
+	var blockedUntil sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT MAX(blocked_until)
+		FROM bank.login_attempts
+		WHERE (scope = $1 AND key = $2) OR (scope = $3 AND key = $4)`,
+		models.LoginScopeEmail, email, models.LoginScopeIP, ip,
+	).Scan(&blockedUntil)
 	if err != nil {
-		return fmt.Errorf("failed to create credit: %w", err)
+		return time.Time{}, fmt.Errorf("failed to check login attempts: %w", err)
 	}
-	return nil
+	return blockedUntil.Time, nil
 }
 
-// FindCreditByID retrieves a credit by its ID
-func (r *Repository) FindCreditByID(creditID int64) (*models.Credit, error) {
-	credit := &models.Credit{}
-	query := `
-		SELECT id, user_id, account_id, amount, interest_rate, term_months, hmac, created_at, updated_at
-		FROM bank.credits
-		WHERE id = $1`
-	err := r.db.QueryRow(query, creditID).Scan(
-		&credit.ID,
-		&credit.UserID,
-		&credit.AccountID,
-		&credit.Amount,
-		&credit.InterestRate,
-		&credit.TermMonths,
-		&credit.HMAC,
-		&credit.CreatedAt,
-		&credit.UpdatedAt,
-	)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("credit not found")
+// RecordLoginFailure counts a failed login for an email address or client IP and blocks
+// further attempts for the duration returned by blockFor. Failures older than window are
+// forgotten.
+func (r *Repository) RecordLoginFailure(ctx context.Context, scope, key string, window time.Duration, blockFor func(failures int) time.Duration) (*models.LoginAttempts, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
+
+	attempts := &models.LoginAttempts{Scope: scope, Key: key}
+	err = tx.QueryRow(`
+		INSERT INTO bank.login_attempts AS a (scope, key, failures, blocked_until, last_failure_at)
+		VALUES ($1, $2, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN a.last_failure_at < $3 THEN 1
+				ELSE a.failures + 1
+			END,
+			last_failure_at = CURRENT_TIMESTAMP
+		RETURNING failures, last_failure_at`,
+		scope, key, time.Now().Add(-window),
+	).Scan(&attempts.Failures, &attempts.LastFailureAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find credit: %w", err)
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
 	}
-	return credit, nil
+
+	attempts.BlockedUntil = attempts.LastFailureAt.Add(blockFor(attempts.Failures))
+	_, err = tx.Exec(`
+		UPDATE bank.login_attempts
+		SET blocked_until = $1
+		WHERE scope = $2 AND key = $3`, attempts.BlockedUntil, scope, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return attempts, nil
 }
 
-// CreatePaymentSchedule creates a new payment schedule entry
-func (r *Repository) CreatePaymentSchedule(payment *models.PaymentSchedule) error {
-	query := `
-		INSERT INTO bank.payment_schedules (
-			credit_id,
-			payment_date,
-			amount,
-			paid,
-			penalty,
-			created_at,
-			updated_at
-		)
-		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, created_at, updated_at`
-	err := r.db.QueryRow(
-		query,
-		payment.CreditID,
-		payment.PaymentDate,
-		payment.Amount,
-		payment.Paid,
-		payment.Penalty,
-	).Scan(&payment.ID, &payment.CreatedAt, &payment.UpdatedAt)
+// ClearLoginFailures forgets the failed logins of an email address or client IP
+func (r *Repository) ClearLoginFailures(scope, key string) error {
+	_, err := r.db.Exec(`DELETE FROM bank.login_attempts WHERE scope = $1 AND key = $2`, scope, key)
 	if err != nil {
-		return fmt.Errorf("failed to create payment schedule: %w", err)
+		return fmt.Errorf("failed to clear login failures: %w", err)
 	}
 	return nil
 }
 
-// ListPaymentSchedules retrieves payment schedules for a credit
-func (r *Repository) ListPaymentSchedules(creditID int64) ([]*models.PaymentSchedule, error) {
-	query := `
-		SELECT id, credit_id, payment_date, amount, paid, penalty, created_at, updated_at
-		FROM bank.payment_schedules
-		WHERE credit_id = $1
-		ORDER BY payment_date ASC`
-	rows, err := r.db.Query(query, creditID)
+// DeleteStaleLoginAttempts removes failed login records that no longer block and whose last
+// failure is older than window
+func (r *Repository) DeleteStaleLoginAttempts(window time.Duration) (int64, error) {
+	result, err := r.db.Exec(`
+		DELETE FROM bank.login_attempts
+		WHERE blocked_until < CURRENT_TIMESTAMP
+		AND last_failure_at < $1`, time.Now().Add(-window))
 	if err != nil {
-		return nil, fmt.Errorf("failed to list payment schedules: %w", err)
+		return 0, fmt.Errorf("failed to delete stale login attempts: %w", err)
 	}
-	defer rows.Close()
-
-	var payments []*models.PaymentSchedule
-	for rows.Next() {
-		payment := &models.PaymentSchedule{}
-		err := rows.Scan(
-			&payment.ID,
-			&payment.CreditID,
-			&payment.PaymentDate,
-			&payment.Amount,
-			&payment.Paid,
-			&payment.Penalty,
-			&payment.CreatedAt,
-			&payment.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
-		}
-		payments = append(payments, payment)
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count stale login attempts: %w", err)
 	}
+	return deleted, nil
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+// apiKeySelect selects all API key columns
+const apiKeySelect = `
+		SELECT id, user_id, name, prefix, key_hash, scopes, last_used_at, revoked_at, created_at
+		FROM bank.api_keys`
+
+// scanAPIKey scans an API key row selected with apiKeySelect
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&lastUsedAt,
+		&revokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
-	return payments, nil
+	key.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
 }
 
-// GetPendingPayments retrieves unpaid payments due today or earlier
-func (r *Repository) GetPendingPayments() ([]*models.PaymentSchedule, error) {
+// CreateAPIKey stores a new API key unless the user already has maxKeys active keys
+func (r *Repository) CreateAPIKey(ctx context.Context, key *models.APIKey, maxKeys int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent creations cannot exceed the limit
+	_, err = tx.Exec(`SELECT id FROM bank.users WHERE id = $1 FOR UPDATE`, key.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+	var active int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM bank.api_keys WHERE user_id = $1 AND revoked_at IS NULL`, key.UserID).Scan(&active)
+	if err != nil {
+		return fmt.Errorf("failed to count API keys: %w", err)
+	}
+	if active >= maxKeys {
+		return fmt.Errorf("API key limit reached")
+	}
+
 	query := `
-		SELECT id, credit_id, payment_date, amount, paid, penalty, created_at, updated_at
-		FROM bank.payment_schedules
-		WHERE paid = FALSE AND payment_date <= $1
-		ORDER BY payment_date ASC`
-	rows, err := r.db.Query(query, time.Now())
+		INSERT INTO bank.api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, created_at`
+	err = tx.QueryRow(query, key.UserID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " ")).
+		Scan(&key.ID, &key.CreatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pending payments: %w", err)
+		return fmt.Errorf("failed to create API key: %w", err)
 	}
-	defer rows.Close()
 
-	var payments []*models.PaymentSchedule
-	for rows.Next() {
-		payment := &models.PaymentSchedule{}
-		err := rows.Scan(
-			&payment.ID,
-			&payment.CreditID,
-			&payment.PaymentDate,
-			&payment.Amount,
-			&payment.Paid,
-			&payment.Penalty,
-			&payment.CreatedAt,
-			&payment.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
-		}
-		payments = append(payments, payment)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return nil
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+// FindAPIKeyByHash retrieves an API key by the hash of the key
+func (r *Repository) FindAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRow(apiKeySelect+` WHERE key_hash = $1`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("API key not found")
 	}
-	return payments, nil
+	if err != nil {
+		return nil, fmt.Errorf("failed to find API key: %w", err)
+	}
+	return key, nil
 }
 
-// GetUpcomingPayments retrieves unpaid payments up to a specific date
-func (r *Repository) GetUpcomingPayments(userID int64, endDate time.Time) ([]*models.PaymentSchedule, error) {
-	query := `
-		SELECT ps.id, ps.credit_id, ps.payment_date, ps.amount, ps.paid, ps.penalty, ps.created_at, ps.updated_at
-		FROM bank.payment_schedules ps
-		JOIN bank.credits c ON ps.credit_id = c.id
-		WHERE c.user_id = $1
-		AND ps.paid = FALSE
-		AND ps.payment_date <= $2
-		ORDER BY ps.payment_date ASC`
-	rows, err := r.db.Query(query, userID, endDate)
+// ListAPIKeys retrieves the API keys of a user, newest first
+func (r *Repository) ListAPIKeys(userID int64) ([]*models.APIKey, error) {
+	rows, err := r.db.Query(apiKeySelect+` WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get upcoming payments: %w", err)
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
 	}
 	defer rows.Close()
 
-	var payments []*models.PaymentSchedule
+	var keys []*models.APIKey
 	for rows.Next() {
-		payment := &models.PaymentSchedule{}
-		err := rows.Scan(
-			&payment.ID,
-			&payment.CreditID,
-			&payment.PaymentDate,
-			&payment.Amount,
-			&payment.Paid,
-			&payment.Penalty,
-			&payment.CreatedAt,
-			&payment.UpdatedAt,
-		)
+		key, err := scanAPIKey(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
 		}
-		payments = append(payments, payment)
+		keys = append(keys, key)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+		return nil, fmt.Errorf("error iterating API keys: %w", err)
 	}
-	return payments, nil
+
+	return keys, nil
 }
 
-// GetUpcomingPaymentsByDate retrieves unpaid payments up to a specific date
-func (r *Repository) GetUpcomingPaymentsByDate(endDate time.Time) ([]*models.PaymentSchedule, error) {
+// RevokeAPIKey revokes an active API key of a user
+func (r *Repository) RevokeAPIKey(userID, keyID int64) error {
 	query := `
-		SELECT id, credit_id, payment_date, amount, paid, penalty, created_at, updated_at
-		FROM bank.payment_schedules
-		WHERE paid = FALSE
-		AND payment_date <= $1
-		ORDER BY payment_date ASC`
-	rows, err := r.db.Query(query, endDate)
+		UPDATE bank.api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := r.db.Exec(query, keyID, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get upcoming payments: %w", err)
+		return fmt.Errorf("failed to revoke API key: %w", err)
 	}
-	defer rows.Close()
-
-	var payments []*models.PaymentSchedule
-	for rows.Next() {
-		payment := &models.PaymentSchedule{}
-		err := rows.Scan(
-			&payment.ID,
-			&payment.CreditID,
-			&payment.PaymentDate,
-			&payment.Amount,
-			&payment.Paid,
-			&payment.Penalty,
-			&payment.CreatedAt,
-			&payment.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan payment schedule: %w", err)
-		}
-		payments = append(payments, payment)
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating payment schedules: %w", err)
+	if updated == 0 {
+		return fmt.Errorf("API key not found")
 	}
-	return payments, nil
+	return nil
 }
 
-// UpdatePaymentSchedule updates a payment schedule entry
-func (r *Repository) UpdatePaymentSchedule(payment *models.PaymentSchedule) error {
+// RevokeUserAPIKeys revokes every active API key of a user
+func (r *Repository) RevokeUserAPIKeys(userID int64) error {
 	query := `
-		UPDATE bank.payment_schedules
-		SET amount = $1,
-			paid = $2,
-			penalty = $3,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = $4`
-	_, err := r.db.Exec(query, payment.Amount, payment.Paid, payment.Penalty, payment.ID)
+		UPDATE bank.api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := r.db.Exec(query, userID)
 	if err != nil {
-		return fmt.Errorf("failed to update payment schedule: %w", err)
+		return fmt.Errorf("failed to revoke API keys: %w", err)
 	}
 	return nil
 }
 
-// GetIncomeExpenseStats retrieves income and expense statistics for a user
-func (r *Repository) GetIncomeExpenseStats(userID int64, startDate, endDate time.Time) (income, expense float64, err error) {
+// TouchSession records the use of an access token in the session of its token family. The
+// timestamp is only written once per interval to avoid a write on every request.
+func (r *Repository) TouchSession(jti string, interval time.Duration) error {
 	query := `
-		SELECT
-			COALESCE(SUM(CASE WHEN t.amount > 0 THEN t.amount ELSE 0 END), 0) as income,
-			COALESCE(SUM(CASE WHEN t.amount < 0 THEN -t.amount ELSE 0 END), 0) as expense
-		FROM bank.transactions t
-		JOIN bank.accounts a ON t.account_id = a.id
-		WHERE a.user_id = $1
-		AND t.created_at BETWEEN $2 AND $3
-		AND t.type IN ('deposit', 'transfer_in', 'withdrawal', 'transfer_out', 'credit_payment')`
-	err = r.db.QueryRow(query, userID, startDate, endDate).Scan(&income, &expense)
+		UPDATE bank.sessions
+		SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE family_id = (SELECT family_id FROM bank.refresh_tokens WHERE access_jti = $1 LIMIT 1)
+		AND last_seen_at < $2`
+	_, err := r.db.Exec(query, jti, time.Now().Add(-interval))
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get income/expense stats: %w", err)
+		return fmt.Errorf("failed to record session activity: %w", err)
 	}
-	return income, expense, nil
+	return nil
 }
 
-// GetTotalBalance retrieves the total balance across all user accounts
-func (r *Repository) GetTotalBalance(userID int64) (float64, error) {
-	var totalBalance float64
+// TouchAPIKey records the use of an API key. The timestamp is only written once per
+// interval to avoid a write on every request.
+func (r *Repository) TouchAPIKey(keyID int64, interval time.Duration) error {
 	query := `
-		SELECT COALESCE(SUM(balance), 0)
-		FROM bank.accounts
-		WHERE user_id = $1`
-	err := r.db.QueryRow(query, userID).Scan(&totalBalance)
+		UPDATE bank.api_keys
+		SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`
+	_, err := r.db.Exec(query, keyID, time.Now().Add(-interval))
 	if err != nil {
-		return 0, fmt.Errorf("failed to get total balance: %w", err)
+		return fmt.Errorf("failed to record API key use: %w", err)
 	}
-	return totalBalance, nil
+	return nil
+}
+
+// DeleteExpiredTokens removes expired refresh and password reset tokens and denylist entries of expired access tokens
+func (r *Repository) DeleteExpiredTokens() (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM bank.revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revoked tokens: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired revoked tokens: %w", err)
+	}
+
+	result, err = r.db.Exec(`DELETE FROM bank.password_reset_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired password reset tokens: %w", err)
+	}
+	reset, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired password reset tokens: %w", err)
+	}
+
+	// Keep used tokens of live families so their reuse is still detected
+	result, err = r.db.Exec(`
+		DELETE FROM bank.refresh_tokens t
+		WHERE expires_at < CURRENT_TIMESTAMP
+		AND NOT EXISTS (
+			SELECT 1 FROM bank.refresh_tokens live
+			WHERE live.family_id = t.family_id
+			AND live.expires_at >= CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+	refresh, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired refresh tokens: %w", err)
+	}
+
+	// Sessions end with their token family
+	_, err = r.db.Exec(`
+		DELETE FROM bank.sessions s
+		WHERE NOT EXISTS (SELECT 1 FROM bank.refresh_tokens t WHERE t.family_id = s.family_id)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete ended sessions: %w", err)
+	}
+	return revoked + reset + refresh, nil
 }
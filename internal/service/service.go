@@ -2,19 +2,25 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"math"
-	"strconv"
+	"math/big"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/Dan9191/bank-service/internal/auth"
 	"github.com/Dan9191/bank-service/internal/config"
 	"github.com/Dan9191/bank-service/internal/integrations/cbr"
 	"github.com/Dan9191/bank-service/internal/models"
 	"github.com/Dan9191/bank-service/internal/repository"
 	"github.com/Dan9191/bank-service/internal/utils"
 	"github.com/Dan9191/bank-service/internal/utils/email"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -28,10 +34,30 @@ type Service struct {
 	cbrClient   *cbr.CBRClient
 	cron        *cron.Cron
 	emailSender *email.Sender
+	keyring     *utils.Keyring
+	tokens      *auth.TokenSigner
+	passwords   *utils.PasswordPolicy
 }
 
 // NewService initializes a new service
-func NewService(repo *repository.Repository, log *logrus.Logger, cfg *config.Config, cbrClient *cbr.CBRClient) *Service {
+func NewService(repo *repository.Repository, log *logrus.Logger, cfg *config.Config, cbrClient *cbr.CBRClient, tokens *auth.TokenSigner) *Service {
+	legacyKey, err := hex.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to decode encryption key: %v", err)
+	}
+	keyring, err := utils.NewKeyring(cfg.EncryptionKeys, cfg.EncryptionKeyVersion, legacyKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize encryption keyring: %v", err)
+	}
+
+	passwords, err := utils.NewPasswordPolicy(cfg.PasswordMinLength, cfg.BreachedPasswordFile)
+	if err != nil {
+		log.Fatalf("Failed to load password policy: %v", err)
+	}
+	if cfg.BreachedPasswordFile != "" {
+		log.Infof("Loaded %d breached passwords", passwords.BreachedCount())
+	}
+
 	svc := &Service{
 		repo:        repo,
 		log:         log,
@@ -39,6 +65,9 @@ func NewService(repo *repository.Repository, log *logrus.Logger, cfg *config.Con
 		cbrClient:   cbrClient,
 		cron:        cron.New(),
 		emailSender: email.NewSender(cfg, log),
+		keyring:     keyring,
+		tokens:      tokens,
+		passwords:   passwords,
 	}
 	svc.startScheduler()
 	return svc
@@ -54,8 +83,35 @@ func (s *Service) startScheduler() {
 	if err != nil {
 		s.log.Fatalf("Failed to start payment reminder scheduler: %v", err)
 	}
+	_, err = s.cron.AddFunc("*/5 * * * *", s.expireStaleHolds)
+	if err != nil {
+		s.log.Fatalf("Failed to start hold expiry scheduler: %v", err)
+	}
+	_, err = s.cron.AddFunc("0 1 * * *", s.expireCards)
+	if err != nil {
+		s.log.Fatalf("Failed to start card expiry scheduler: %v", err)
+	}
+	_, err = s.cron.AddFunc("0 0 * * *", s.resetCardDailySpending)
+	if err != nil {
+		s.log.Fatalf("Failed to start daily card spending reset scheduler: %v", err)
+	}
+	_, err = s.cron.AddFunc("0 0 1 * *", s.resetCardMonthlySpending)
+	if err != nil {
+		s.log.Fatalf("Failed to start monthly card spending reset scheduler: %v", err)
+	}
+	_, err = s.cron.AddFunc("@hourly", s.deleteExpiredTokens)
+	if err != nil {
+		s.log.Fatalf("Failed to start token cleanup scheduler: %v", err)
+	}
+	_, err = s.cron.AddFunc("@hourly", s.deleteStaleLoginAttempts)
+	if err != nil {
+		s.log.Fatalf("Failed to start login attempt cleanup scheduler: %v", err)
+	}
 	s.cron.Start()
-	s.log.Info("Payment and reminder schedulers started")
+	s.log.Info("Payment, reminder, hold, card, token and login attempt cleanup schedulers started")
+
+	go s.backfillCardLookupFields()
+	go s.backfillAccountNumbers()
 }
 
 // calculateAnnuityPayment calculates the monthly annuity payment
@@ -95,6 +151,95 @@ func (s *Service) getUserByID(userID int64) (*models.User, error) {
 	return user, nil
 }
 
+// requirePrincipal returns the authenticated principal of a request
+func requirePrincipal(ctx context.Context) (*auth.Principal, error) {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("user ID not found in context")
+	}
+	return principal, nil
+}
+
+// authenticatedUserID returns the ID of the authenticated user of a request
+func authenticatedUserID(ctx context.Context) (int64, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return principal.UserID, nil
+}
+
+// canAccessUser reports whether a principal may act on resources owned by a user. Rules for
+// acting on behalf of other users belong here.
+func canAccessUser(principal *auth.Principal, ownerID int64) bool {
+	return principal.UserID == ownerID
+}
+
+// canAccessAccount reports whether a principal may act on an account
+func (s *Service) canAccessAccount(principal *auth.Principal, accountID int64) (bool, error) {
+	ownerID, err := s.repo.FindAccountByID(accountID)
+	if err != nil {
+		return false, err
+	}
+	return canAccessUser(principal, ownerID), nil
+}
+
+// requireActiveAccount ensures an account is neither frozen nor closed
+func (s *Service) requireActiveAccount(accountID int64) error {
+	account, err := s.repo.GetAccount(accountID)
+	if err != nil {
+		return err
+	}
+	if account.Status != models.AccountStatusActive {
+		return fmt.Errorf("account %s", account.Status)
+	}
+	return nil
+}
+
+// authorizeAccount ensures the authenticated principal may act on an account
+func (s *Service) authorizeAccount(ctx context.Context, accountID int64) error {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return err
+	}
+	allowed, err := s.canAccessAccount(principal, accountID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("account does not belong to user")
+	}
+	return nil
+}
+
+// requireAdmin ensures the authenticated user has the admin role
+func (s *Service) requireAdmin(ctx context.Context) (int64, error) {
+	return s.requireRole(ctx, models.RoleAdmin)
+}
+
+// requireRole ensures the authenticated user currently has one of the given roles. The role
+// is read from the database so that demotions apply before access tokens expire.
+func (s *Service) requireRole(ctx context.Context, roles ...string) (int64, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// Staff operations are never available to API keys
+	if principal.APIKeyID != 0 {
+		return 0, fmt.Errorf("insufficient role")
+	}
+	userID := principal.UserID
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(roles, user.Role) {
+		return 0, fmt.Errorf("insufficient role")
+	}
+	return userID, nil
+}
+
 // processPendingPayments processes all pending payments
 func (s *Service) processPendingPayments() {
 	ctx := context.Background()
@@ -121,8 +266,8 @@ func (s *Service) processPendingPayments() {
 			continue
 		}
 
-		// Check account balance
-		balance, err := s.repo.GetAccountBalance(credit.AccountID)
+		// Check available account balance
+		balance, err := s.repo.GetAvailableBalance(credit.AccountID)
 		if err != nil {
 			s.log.Errorf("Failed to get balance for account %d: %v", credit.AccountID, err)
 			continue
@@ -220,16 +365,154 @@ func (s *Service) sendUpcomingPaymentReminders() {
 	}
 }
 
-// GetIncomeExpenseStats retrieves income and expense statistics for the user
-func (s *Service) GetIncomeExpenseStats(ctx context.Context, year, month int) (*models.IncomeExpenseStats, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// expireStaleHolds releases pending holds that were neither captured nor released in time
+func (s *Service) expireStaleHolds() {
+	expired, err := s.repo.ExpireHolds()
+	if err != nil {
+		s.log.Errorf("Failed to expire stale holds: %v", err)
+		return
+	}
+	if expired > 0 {
+		s.log.Infof("Expired %d stale holds", expired)
+	}
+}
+
+// expireCards marks cards past their expiry date as expired. Cards created before the
+// plaintext expiry date was recorded have it backfilled from the encrypted expiry date first;
+// cards whose expiry date cannot be read are skipped so they do not hold up the others.
+func (s *Service) expireCards() {
+	var lastID int64
+	for {
+		cards, err := s.repo.ListCardsWithoutExpiry(lastID, 100)
+		if err != nil {
+			s.log.Errorf("Failed to list cards without expiry: %v", err)
+			break
+		}
+		if len(cards) == 0 {
+			break
+		}
+
+		for _, card := range cards {
+			lastID = card.ID
+			expiryDate, err := s.keyring.Decrypt(card.ExpiryDate)
+			if err != nil {
+				s.log.Errorf("Failed to decrypt expiry date for card ID %d: %v", card.ID, err)
+				continue
+			}
+			validUntil, err := utils.ParseExpiryDate(expiryDate)
+			if err != nil {
+				s.log.Errorf("Invalid stored expiry date for card ID %d: %v", card.ID, err)
+				continue
+			}
+			if err := s.repo.SetCardExpiresOn(card.ID, validUntil.AddDate(0, 0, -1)); err != nil {
+				s.log.Errorf("Failed to backfill expiry for card ID %d: %v", card.ID, err)
+				continue
+			}
+		}
+	}
+
+	expired, err := s.repo.ExpireCards()
+	if err != nil {
+		s.log.Errorf("Failed to expire cards: %v", err)
+		return
+	}
+	if expired > 0 {
+		s.log.Infof("Marked %d cards as expired", expired)
+	}
+}
+
+// resetCardDailySpending resets the daily usage counters of card limits
+func (s *Service) resetCardDailySpending() {
+	reset, err := s.repo.ResetCardDailySpending()
+	if err != nil {
+		s.log.Errorf("Failed to reset daily card spending: %v", err)
+		return
+	}
+	s.log.Infof("Reset daily spending of %d cards", reset)
+}
+
+// resetCardMonthlySpending resets the monthly usage counters of card limits
+func (s *Service) resetCardMonthlySpending() {
+	reset, err := s.repo.ResetCardMonthlySpending()
+	if err != nil {
+		s.log.Errorf("Failed to reset monthly card spending: %v", err)
+		return
+	}
+	s.log.Infof("Reset monthly spending of %d cards", reset)
+}
+
+// backfillCardLookupFields records PAN fingerprints and masked PANs for cards issued before they were introduced
+func (s *Service) backfillCardLookupFields() {
+	var lastID int64
+	backfilled := 0
+	for {
+		cards, err := s.repo.ListCardsWithoutLookupFields(lastID, 100)
+		if err != nil {
+			s.log.Errorf("Failed to list cards without lookup fields: %v", err)
+			return
+		}
+		if len(cards) == 0 {
+			break
+		}
+
+		for _, card := range cards {
+			lastID = card.ID
+			cardNumber, err := s.keyring.Decrypt(card.CardNumber)
+			if err != nil {
+				s.log.Errorf("Failed to decrypt card number for card ID %d: %v", card.ID, err)
+				continue
+			}
+			fingerprint := utils.PANFingerprint(cardNumber, s.config.PANFingerprintKey)
+			if err := s.repo.SetCardLookupFields(card.ID, fingerprint, utils.MaskPAN(cardNumber)); err != nil {
+				s.log.Errorf("Failed to backfill lookup fields for card ID %d: %v", card.ID, err)
+				continue
+			}
+			backfilled++
+		}
+	}
+
+	if backfilled > 0 {
+		s.log.Infof("Backfilled PAN fingerprints and masked PANs for %d cards", backfilled)
+	}
+}
+
+// backfillAccountNumbers assigns account numbers to accounts opened before they were introduced
+func (s *Service) backfillAccountNumbers() {
+	var lastID int64
+	backfilled := 0
+	for {
+		accounts, err := s.repo.ListAccountsWithoutNumber(lastID, 100)
+		if err != nil {
+			s.log.Errorf("Failed to list accounts without number: %v", err)
+			return
+		}
+		if len(accounts) == 0 {
+			break
+		}
+
+		for _, account := range accounts {
+			lastID = account.ID
+			err := s.withAccountNumber(account.Currency, func(number string) error {
+				return s.repo.SetAccountNumber(account.ID, number)
+			})
+			if err != nil {
+				s.log.Errorf("Failed to backfill number for account ID %d: %v", account.ID, err)
+				continue
+			}
+			backfilled++
+		}
+	}
+
+	if backfilled > 0 {
+		s.log.Infof("Backfilled account numbers for %d accounts", backfilled)
 	}
+}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+// GetIncomeExpenseStats retrieves income and expense statistics for the user
+func (s *Service) GetIncomeExpenseStats(ctx context.Context, year, month int) (*models.IncomeExpenseStats, error) {
+	userID, err := authenticatedUserID(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, err
 	}
 
 	// Calculate start and end dates for the specified month
@@ -253,14 +536,9 @@ func (s *Service) GetIncomeExpenseStats(ctx context.Context, year, month int) (*
 
 // GetCreditBurden retrieves credit burden analytics for the user
 func (s *Service) GetCreditBurden(ctx context.Context) (*models.CreditBurden, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
-	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	userID, err := authenticatedUserID(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, err
 	}
 
 	// Get upcoming payments for the next 30 days
@@ -300,14 +578,9 @@ func (s *Service) GetCreditBurden(ctx context.Context) (*models.CreditBurden, er
 
 // ForecastBalance forecasts the balance for N days
 func (s *Service) ForecastBalance(ctx context.Context, days int) (*models.BalanceForecast, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
-	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	userID, err := authenticatedUserID(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, err
 	}
 
 	// Validate days
@@ -360,6 +633,14 @@ func (s *Service) ForecastBalance(ctx context.Context, days int) (*models.Balanc
 
 // Register creates a new user with hashed password
 func (s *Service) Register(username, email, password string) (*models.User, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return nil, fmt.Errorf("invalid email address")
+	}
+	if err := s.passwords.Check(password, username, email); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -376,184 +657,1665 @@ func (s *Service) Register(username, email, password string) (*models.User, erro
 		return nil, err
 	}
 
+	s.sendEmailVerification(user)
+
 	s.log.Infof("User registered: %s", user.Email)
 	return user, nil
 }
 
-// Login authenticates a user and returns a JWT token
-func (s *Service) Login(email, password string) (string, error) {
-	user, err := s.repo.FindUserByEmail(email)
+// emailVerificationResendInterval is the minimum time between verification emails to a user
+const emailVerificationResendInterval = time.Minute
+
+// sendEmailVerification sends a verification token to the user's email address, or to the
+// requested new address while an email change is pending. Failures are logged rather than
+// returned so the user can request another email.
+func (s *Service) sendEmailVerification(user *models.User) bool {
+	claimed, err := s.repo.ClaimEmailVerificationSend(user.ID, emailVerificationResendInterval)
 	if err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		s.log.Errorf("Failed to record verification email for user %d: %v", user.ID, err)
+		return false
 	}
-
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return "", fmt.Errorf("invalid credentials")
+	if !claimed {
+		return false
 	}
 
-	// Generate JWT
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   fmt.Sprintf("%d", user.ID),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-	})
-	tokenString, err := token.SignedString([]byte(s.config.JWTSecret))
-	if err != nil {
-		return "", fmt.Errorf("failed to generate token: %w", err)
+	expiresAt := time.Now().Add(s.config.EmailVerificationTTL)
+	if user.PendingEmail != "" {
+		token := utils.NewSignedToken(s.config.EmailTokenKey, "email-change", user.ID, user.PendingEmail, expiresAt)
+		if err := s.emailSender.SendEmailChangeVerification(user.PendingEmail, user.Username, token, expiresAt); err != nil {
+			s.log.Errorf("Failed to send email change verification to user %d: %v", user.ID, err)
+		}
+		return true
 	}
-
-	s.log.Infof("User logged in: %s", user.Email)
-	return tokenString, nil
+	token := utils.NewSignedToken(s.config.EmailTokenKey, "email-verification", user.ID, user.Email, expiresAt)
+	if err := s.emailSender.SendEmailVerification(user.Email, user.Username, token, expiresAt); err != nil {
+		s.log.Errorf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+	return true
 }
 
-// CreateAccount creates a new account for the authenticated user
-func (s *Service) CreateAccount(ctx context.Context, currency string) (*models.Account, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// VerifyEmail marks a user's email address as verified using a token sent to that address.
+// A token sent to a pending new address completes the email change and notifies the
+// previous address.
+func (s *Service) VerifyEmail(token string) error {
+	userID, _, err := utils.SignedTokenUserID(token)
+	if err != nil {
+		return fmt.Errorf("invalid verification token")
 	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	user, err := s.repo.FindUserByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return fmt.Errorf("invalid verification token")
 	}
 
-	account := &models.Account{
-		UserID:   userID,
-		Balance:  0.0,
-		Currency: currency,
+	if user.PendingEmail != "" && utils.VerifySignedToken(s.config.EmailTokenKey, "email-change", token, user.ID, user.PendingEmail) {
+		if err := s.repo.ConfirmEmailChange(user.ID, user.PendingEmail); err != nil {
+			return err
+		}
+		if err := s.emailSender.SendEmailChanged(user.Email, user.Username, user.PendingEmail); err != nil {
+			s.log.Errorf("Failed to send email change notice to user %d: %v", user.ID, err)
+		}
+		s.log.Infof("Email changed for user %d", user.ID)
+		return nil
 	}
 
-	if err := s.repo.CreateAccount(account); err != nil {
-		return nil, err
+	if !utils.VerifySignedToken(s.config.EmailTokenKey, "email-verification", token, user.ID, user.Email) {
+		return fmt.Errorf("invalid verification token")
+	}
+	if err := s.repo.MarkEmailVerified(user.ID, user.Email); err != nil {
+		return err
 	}
 
-	s.log.Infof("Account created for user %d: %s", userID, account.Currency)
-	return account, nil
+	s.log.Infof("Email verified for user %d", user.ID)
+	return nil
 }
 
-// CreateCard creates a new card for the specified account
-func (s *Service) CreateCard(ctx context.Context, accountID int64) (*models.Card, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
-	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+// ResendEmailVerification sends a new verification email to the authenticated user
+func (s *Service) ResendEmailVerification(ctx context.Context) error {
+	userID, err := authenticatedUserID(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return err
 	}
 
-	// Verify account belongs to user
-	accountUserID, err := s.repo.FindAccountByID(accountID)
+	user, err := s.getUserByID(userID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if accountUserID != userID {
-		return nil, fmt.Errorf("account does not belong to user")
+	if user.EmailVerifiedAt != nil && user.PendingEmail == "" {
+		return fmt.Errorf("email already verified")
 	}
-
-	// Generate card details
-	cardNumber, err := utils.GenerateCardNumber("400000", 16)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate card number: %w", err)
+	if !s.sendEmailVerification(user) {
+		return fmt.Errorf("verification email recently sent")
 	}
-	expiryDate := utils.GenerateExpiryDate()
-	cvv := utils.GenerateCVV()
+	return nil
+}
 
-	// Decode encryption key from hex
-	encryptionKey, err := hex.DecodeString(s.config.EncryptionKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
-	}
-	if len(encryptionKey) != 32 {
-		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(encryptionKey))
-	}
+// ForgotPassword emails a one-time password reset token if the email belongs to a user. It
+// reports success either way, and does the work in the background so that response timing
+// does not reveal whether the email exists.
+func (s *Service) ForgotPassword(email string) {
+	go func() {
+		user, err := s.repo.FindUserByEmail(email)
+		if err != nil {
+			if err.Error() != "user not found" {
+				s.log.Errorf("Failed to look up user for password reset: %v", err)
+			}
+			return
+		}
 
-	// Encrypt card number and expiry date
-	encryptedCardNumber, err := utils.Encrypt(cardNumber, encryptionKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt card number: %w", err)
+		token, err := utils.GenerateToken(32)
+		if err != nil {
+			s.log.Errorf("Failed to generate password reset token for user %d: %v", user.ID, err)
+			return
+		}
+		resetToken := &models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(s.config.PasswordResetTTL),
+		}
+		if err := s.repo.CreatePasswordResetToken(context.Background(), resetToken); err != nil {
+			s.log.Errorf("Failed to store password reset token for user %d: %v", user.ID, err)
+			return
+		}
+		if err := s.emailSender.SendPasswordReset(user.Email, user.Username, token, resetToken.ExpiresAt); err != nil {
+			s.log.Errorf("Failed to send password reset email to user %d: %v", user.ID, err)
+			return
+		}
+		s.log.Infof("Password reset requested for user %d", user.ID)
+	}()
+}
+
+// hashNewPassword checks a new password against the password policy and hashes it
+func (s *Service) hashNewPassword(user *models.User, password string) (string, error) {
+	if err := s.passwords.Check(password, user.Username, user.Email); err != nil {
+		return "", err
 	}
-	encryptedExpiryDate, err := utils.Encrypt(expiryDate, encryptionKey)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt expiry date: %w", err)
+		return "", fmt.Errorf("failed to hash password: %w", err)
 	}
+	return string(hashedPassword), nil
+}
 
-	// Hash CVV
-	cvvHash, err := bcrypt.GenerateFromPassword([]byte(cvv), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash CVV: %w", err)
+// passwordChanged revokes every session and API key of a user whose password changed and
+// notifies them
+func (s *Service) passwordChanged(user *models.User) error {
+	if err := s.repo.RevokeUserTokens(user.ID); err != nil {
+		return err
 	}
+	if err := s.repo.RevokeUserAPIKeys(user.ID); err != nil {
+		return err
+	}
+	if err := s.emailSender.SendPasswordChanged(user.Email, user.Username); err != nil {
+		s.log.Errorf("Failed to send password change notification to user %d: %v", user.ID, err)
+	}
+	return nil
+}
 
-	// Generate HMAC
-	hmac := utils.GenerateHMAC(cardNumber, expiryDate, cvv, s.config.HMACSecret)
+// ResetPassword sets a new password using a password reset token and signs out all sessions
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if token == "" {
+		return fmt.Errorf("invalid or expired reset token")
+	}
 
-	card := &models.Card{
-		AccountID:  accountID,
-		CardNumber: encryptedCardNumber,
-		ExpiryDate: encryptedExpiryDate,
-		CVV:        string(cvvHash),
-		HMAC:       hmac,
+	var user *models.User
+	_, err := s.repo.ResetPassword(ctx, utils.HashToken(token), func(userID int64) (string, error) {
+		found, err := s.getUserByID(userID)
+		if err != nil {
+			return "", err
+		}
+		user = found
+		return s.hashNewPassword(user, newPassword)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Store card with encrypted fields
-	if err := s.repo.CreateCard(card); err != nil {
-		return nil, err
+	if err := s.passwordChanged(user); err != nil {
+		return err
 	}
 
-	// Return card with decrypted fields for response
-	card.CardNumber = cardNumber
-	card.ExpiryDate = expiryDate
-	s.log.Infof("Card created for account %d", accountID)
-	return card, nil
+	s.log.Infof("Password reset for user %d", user.ID)
+	return nil
 }
 
-// CreateCredit creates a new credit with payment schedule
-func (s *Service) CreateCredit(ctx context.Context, accountID int64, amount float64, termMonths int) (*models.Credit, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// ChangePassword replaces the authenticated user's password, signs out all sessions and
+// returns new tokens for the current client. Users with two-factor authentication must also
+// present a one-time code.
+func (s *Service) ChangePassword(ctx context.Context, currentPassword, newPassword, otp, ipAddress, userAgent string) (*models.TokenPair, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	user, err := s.getUserByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, err
+	}
+	if err := s.verifyPassword(ctx, user, currentPassword); err != nil {
+		return nil, err
+	}
+	amr := []string{models.AuthMethodPassword}
+	if user.TwoFactorEnabled {
+		if err := s.verifyStepUp(ctx, user, models.StepUp{OTP: otp}); err != nil {
+			return nil, err
+		}
+		amr = append(amr, models.AuthMethodOTP)
+	}
+	if currentPassword == newPassword {
+		return nil, fmt.Errorf("new password must differ from the current password")
 	}
 
-	// Verify account belongs to user
-	accountUserID, err := s.repo.FindAccountByID(accountID)
+	passwordHash, err := s.hashNewPassword(user, newPassword)
 	if err != nil {
 		return nil, err
 	}
-	if accountUserID != userID {
-		return nil, fmt.Errorf("account does not belong to user")
+	if err := s.repo.UpdateUserPassword(userID, passwordHash); err != nil {
+		return nil, err
 	}
-
-	// Validate input
-	if amount <= 0 {
-		return nil, fmt.Errorf("credit amount must be positive")
+	if err := s.passwordChanged(user); err != nil {
+		return nil, err
 	}
-	if termMonths <= 0 || termMonths > 360 {
-		return nil, fmt.Errorf("term must be between 1 and 360 months")
+	// Tokens issued outside a token family are not covered by revoking the user's families
+	if principal, ok := auth.PrincipalFromContext(ctx); ok && principal.TokenID != "" {
+		if err := s.repo.RevokeAccessToken(principal.TokenID, userID, time.Now().Add(s.config.AccessTokenTTL)); err != nil {
+			return nil, err
+		}
 	}
 
-	// Get interest rate from CBR
-	interestRate, err := s.cbrClient.GetKeyRate()
+	tokens, err := s.startTokenFamily(ctx, userID, amr, ipAddress, userAgent)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interest rate: %w", err)
+		return nil, err
 	}
 
-	// Generate HMAC for credit
-	hmac := utils.GenerateHMAC(
-		fmt.Sprintf("%d", userID),
-		fmt.Sprintf("%d", accountID),
-		fmt.Sprintf("%.2f", amount),
-		s.config.HMACSecret,
-	)
+	s.log.Infof("Password changed for user %d", userID)
+	return tokens, nil
+}
 
-	credit := &models.Credit{
-		UserID:       userID,
+// requireVerifiedEmail ensures a user has verified their email address before moving money
+func (s *Service) requireVerifiedEmail(userID int64) error {
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return fmt.Errorf("email not verified")
+	}
+	return nil
+}
+
+// mfaTokenTTL is how long the second login step may be completed after the password was verified
+const mfaTokenTTL = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes generated when enabling two-factor authentication
+const recoveryCodeCount = 10
+
+// Login authenticates a user and returns an access token and a refresh token starting a new
+// token family. When two-factor authentication is enabled no tokens are issued; instead a
+// challenge is returned that must be completed with CompleteMFALogin.
+func (s *Service) Login(ctx context.Context, email, password, ip, userAgent string) (*models.TokenPair, *models.MFAChallenge, error) {
+	if err := s.checkLoginThrottle(email, ip); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.repo.FindUserByEmail(email)
+	if err != nil {
+		s.recordLoginFailure(ctx, email, ip, nil)
+		return nil, nil, fmt.Errorf("invalid credentials")
+	}
+
+	// Verify password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		s.recordLoginFailure(ctx, email, ip, user)
+		return nil, nil, fmt.Errorf("invalid credentials")
+	}
+
+	if user.TwoFactorEnabled {
+		// Binding the password hash invalidates pending challenges when the password changes
+		token := utils.NewSignedToken(s.config.MFATokenKey, "mfa-login", user.ID, user.PasswordHash, time.Now().Add(mfaTokenTTL))
+		s.log.Infof("Second factor required for user %d", user.ID)
+		return nil, &models.MFAChallenge{
+			MFARequired: true,
+			MFAToken:    token,
+			ExpiresIn:   int64(mfaTokenTTL / time.Second),
+		}, nil
+	}
+
+	tokens, err := s.startTokenFamily(ctx, user.ID, []string{models.AuthMethodPassword}, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.clearLoginFailures(email)
+
+	s.log.Infof("User logged in: %s", user.Email)
+	return tokens, nil, nil
+}
+
+// checkLoginThrottle rejects a login while the email address or client IP is backing off
+// or locked out after failed attempts
+func (s *Service) checkLoginThrottle(email, ip string) error {
+	blockedUntil, err := s.repo.LoginBlockedUntil(loginKey(email), ip)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(blockedUntil) {
+		return fmt.Errorf("too many login attempts")
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the email address and client IP. The
+// delay before the next attempt doubles with each failure until the configured maximum is
+// reached, which locks logins for the lockout duration and notifies the user, if any.
+func (s *Service) recordLoginFailure(ctx context.Context, email, ip string, user *models.User) {
+	attempts, err := s.repo.RecordLoginFailure(ctx, models.LoginScopeEmail, loginKey(email), s.config.LoginLockoutDuration, func(failures int) time.Duration {
+		return s.loginBackoff(failures, s.config.LoginMaxFailures)
+	})
+	if err != nil {
+		s.log.Errorf("Failed to record login failure: %v", err)
+	} else if attempts.Failures >= s.config.LoginMaxFailures {
+		s.log.Warnf("Logins for %s locked until %s after %d failed attempts", attempts.Key, attempts.BlockedUntil.Format(time.RFC3339), attempts.Failures)
+		if user != nil {
+			if err := s.emailSender.SendAccountLocked(user.Email, user.Username, attempts.BlockedUntil); err != nil {
+				s.log.Errorf("Failed to send account lockout notification to user %d: %v", user.ID, err)
+			}
+		}
+	}
+
+	if ip == "" {
+		return
+	}
+	attempts, err = s.repo.RecordLoginFailure(ctx, models.LoginScopeIP, ip, s.config.LoginLockoutDuration, func(failures int) time.Duration {
+		return s.loginBackoff(failures, s.config.LoginIPMaxFailures)
+	})
+	if err != nil {
+		s.log.Errorf("Failed to record login failure: %v", err)
+	} else if attempts.Failures >= s.config.LoginIPMaxFailures {
+		s.log.Warnf("Logins from %s locked until %s after %d failed attempts", ip, attempts.BlockedUntil.Format(time.RFC3339), attempts.Failures)
+	}
+}
+
+// loginBackoff returns how long to block logins after a number of failures
+func (s *Service) loginBackoff(failures, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return s.config.LoginLockoutDuration
+	}
+	backoff := s.config.LoginBackoffBase
+	for i := 1; i < failures && backoff < s.config.LoginLockoutDuration; i++ {
+		backoff *= 2
+	}
+	if backoff > s.config.LoginLockoutDuration {
+		return s.config.LoginLockoutDuration
+	}
+	return backoff
+}
+
+// clearLoginFailures forgets the failed logins of an email address after a successful login
+func (s *Service) clearLoginFailures(email string) {
+	if err := s.repo.ClearLoginFailures(models.LoginScopeEmail, loginKey(email)); err != nil {
+		s.log.Errorf("Failed to clear login failures: %v", err)
+	}
+}
+
+// loginKey normalizes an email address for failed login tracking
+func loginKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UnlockUser lifts a login lockout of a user
+func (s *Service) UnlockUser(ctx context.Context, userID int64) error {
+	adminID, err := s.requireRole(ctx, models.RoleOperator, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.ClearLoginFailures(models.LoginScopeEmail, loginKey(user.Email)); err != nil {
+		return err
+	}
+
+	s.log.Infof("Logins for user %d unlocked by staff user %d", userID, adminID)
+	return nil
+}
+
+// staffRoles are the roles allowed to view any customer's data
+var staffRoles = []string{models.RoleSupport, models.RoleOperator, models.RoleAdmin}
+
+// SearchUsers lists users matching an email, username or ID for staff
+func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset int) ([]*models.User, error) {
+	staffID, err := s.requireRole(ctx, staffRoles...)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	users, err := s.repo.SearchUsers(strings.TrimSpace(query), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Staff user %d searched users for %q: %d results", staffID, query, len(users))
+	return users, nil
+}
+
+// AdminGetUser retrieves any user for staff
+func (s *Service) AdminGetUser(ctx context.Context, userID int64) (*models.User, error) {
+	if _, err := s.requireRole(ctx, staffRoles...); err != nil {
+		return nil, err
+	}
+	return s.repo.FindUserByID(userID)
+}
+
+// AdminGetAccount retrieves any account for staff
+func (s *Service) AdminGetAccount(ctx context.Context, accountID int64) (*models.Account, error) {
+	staffID, err := s.requireRole(ctx, staffRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.repo.GetAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Staff user %d viewed account %d", staffID, accountID)
+	return account, nil
+}
+
+// AdminGetCredit retrieves any credit with its payment schedule for staff
+func (s *Service) AdminGetCredit(ctx context.Context, creditID int64) (*models.Credit, []*models.PaymentSchedule, error) {
+	staffID, err := s.requireRole(ctx, staffRoles...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credit, err := s.repo.FindCreditByID(creditID)
+	if err != nil {
+		return nil, nil, err
+	}
+	payments, err := s.repo.ListPaymentSchedules(creditID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.log.Infof("Staff user %d viewed credit %d", staffID, creditID)
+	return credit, payments, nil
+}
+
+// AdminGetCard retrieves any card for staff. Like customer listings it exposes only the masked PAN.
+func (s *Service) AdminGetCard(ctx context.Context, cardID int64) (*models.Card, error) {
+	staffID, err := s.requireRole(ctx, staffRoles...)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := s.repo.FindCardByID(cardID)
+	if err != nil {
+		return nil, err
+	}
+	card.CardNumber = ""
+	card.ExpiryDate = ""
+
+	s.log.Infof("Staff user %d viewed card %d", staffID, cardID)
+	return card, nil
+}
+
+// SetUserFrozen freezes or unfreezes a user. Freezing signs the user out everywhere and
+// prevents new sign-ins; admins can only be frozen by other admins.
+func (s *Service) SetUserFrozen(ctx context.Context, userID int64, frozen bool) error {
+	staffID, err := s.requireRole(ctx, models.RoleOperator, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if userID == staffID {
+		return fmt.Errorf("cannot freeze own user")
+	}
+
+	user, err := s.repo.FindUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == models.RoleAdmin {
+		if _, err := s.requireAdmin(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.SetUserFrozen(userID, frozen); err != nil {
+		return err
+	}
+	if frozen {
+		if err := s.repo.RevokeUserTokens(userID); err != nil {
+			return err
+		}
+		s.log.Warnf("User %d frozen by staff user %d", userID, staffID)
+	} else {
+		s.log.Infof("User %d unfrozen by staff user %d", userID, staffID)
+	}
+	return nil
+}
+
+// SetUserRole changes the role of a user. The user is signed out so that new tokens carry
+// the new role.
+func (s *Service) SetUserRole(ctx context.Context, userID int64, role string) error {
+	adminID, err := s.requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if !models.ValidRole(role) {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	if userID == adminID {
+		return fmt.Errorf("cannot change own role")
+	}
+
+	if err := s.repo.SetUserRole(userID, role); err != nil {
+		return err
+	}
+	if err := s.repo.RevokeUserTokens(userID); err != nil {
+		return err
+	}
+
+	s.log.Warnf("Role of user %d set to %s by admin %d", userID, role, adminID)
+	return nil
+}
+
+// deleteStaleLoginAttempts removes failed login records that no longer affect logins
+func (s *Service) deleteStaleLoginAttempts() {
+	deleted, err := s.repo.DeleteStaleLoginAttempts(s.config.LoginLockoutDuration)
+	if err != nil {
+		s.log.Errorf("Failed to delete stale login attempts: %v", err)
+		return
+	}
+	if deleted > 0 {
+		s.log.Infof("Deleted %d stale login attempt records", deleted)
+	}
+}
+
+// CompleteMFALogin finishes a two-step login with the challenge token returned by Login and
+// a TOTP or recovery code. Wrong codes count as failed logins.
+func (s *Service) CompleteMFALogin(ctx context.Context, mfaToken, code, ip, userAgent string) (*models.TokenPair, error) {
+	userID, _, err := utils.SignedTokenUserID(mfaToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired MFA token")
+	}
+	user, err := s.repo.FindUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired MFA token")
+	}
+	if !user.TwoFactorEnabled || !utils.VerifySignedToken(s.config.MFATokenKey, "mfa-login", mfaToken, user.ID, user.PasswordHash) {
+		return nil, fmt.Errorf("invalid or expired MFA token")
+	}
+
+	if err := s.checkLoginThrottle(user.Email, ip); err != nil {
+		return nil, err
+	}
+	if err := s.verifyOTP(user, code); err != nil {
+		s.log.Warnf("Failed second factor for user %d", user.ID)
+		s.recordLoginFailure(ctx, user.Email, ip, user)
+		return nil, err
+	}
+
+	tokens, err := s.startTokenFamily(ctx, user.ID, []string{models.AuthMethodPassword, models.AuthMethodOTP}, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	s.clearLoginFailures(user.Email)
+
+	s.log.Infof("User logged in with second factor: %s", user.Email)
+	return tokens, nil
+}
+
+// startTokenFamily issues and stores the first tokens of a new token family and records the
+// session of the client. Logins from a user agent not seen before are notified by email.
+func (s *Service) startTokenFamily(ctx context.Context, userID int64, amr []string, ipAddress, userAgent string) (*models.TokenPair, error) {
+	familyID, err := utils.GenerateToken(16)
+	if err != nil {
+		return nil, err
+	}
+	tokens, refreshToken, err := s.issueTokens(userID, familyID, amr)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	session := &models.Session{
+		UserID:    userID,
+		FamilyID:  familyID,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+	}
+	newDevice, err := s.repo.CreateSession(ctx, session, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if newDevice {
+		// Sent in the background so that the login does not wait for the mail server
+		go func() {
+			user, err := s.getUserByID(userID)
+			if err != nil {
+				s.log.Errorf("Failed to load user %d for new device notification: %v", userID, err)
+			} else if err := s.emailSender.SendNewDeviceLogin(user.Email, user.Username, ipAddress, userAgent, session.CreatedAt); err != nil {
+				s.log.Errorf("Failed to send new device notification to user %d: %v", userID, err)
+			}
+		}()
+	}
+	return tokens, nil
+}
+
+// ListSessions retrieves the active sessions of the authenticated user
+func (s *Service) ListSessions(ctx context.Context) ([]*models.Session, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListSessions(principal.UserID, principal.TokenID)
+}
+
+// RevokeSession signs out one session of the authenticated user
+func (s *Service) RevokeSession(ctx context.Context, sessionID int64) error {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.RevokeSession(ctx, principal.UserID, sessionID); err != nil {
+		return err
+	}
+
+	s.log.Infof("Session %d of user %d revoked", sessionID, principal.UserID)
+	return nil
+}
+
+// phonePattern matches a phone number in E.164 format
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// GetProfile returns the profile of the authenticated user
+func (s *Service) GetProfile(ctx context.Context) (*models.User, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.getUserByID(userID)
+}
+
+// UpdateProfile changes the profile of the authenticated user. Changing the email address
+// requires step-up verification; the new address is kept pending, and the current address
+// stays in use, until the new one is verified.
+func (s *Service) UpdateProfile(ctx context.Context, update models.ProfileUpdate) (*models.User, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Username != nil {
+		username := strings.TrimSpace(*update.Username)
+		if username == "" || len(username) > 50 {
+			return nil, fmt.Errorf("username must be 1 to 50 characters")
+		}
+		user.Username = username
+	}
+	newEmail := ""
+	if update.Email != nil && *update.Email != user.Email {
+		address, err := mail.ParseAddress(*update.Email)
+		if err != nil || address.Address != *update.Email || len(*update.Email) > 100 {
+			return nil, fmt.Errorf("invalid email address")
+		}
+		newEmail = *update.Email
+	}
+	if update.Phone != nil {
+		phone := strings.TrimSpace(*update.Phone)
+		if phone != "" && !phonePattern.MatchString(phone) {
+			return nil, fmt.Errorf("phone must be in E.164 format")
+		}
+		user.Phone = phone
+	}
+	if update.FullName != nil {
+		fullName := strings.TrimSpace(*update.FullName)
+		if len(fullName) > 200 {
+			return nil, fmt.Errorf("full name must be at most 200 characters")
+		}
+		user.FullName = fullName
+	}
+
+	if newEmail != "" {
+		if err := s.verifyStepUp(ctx, user, update.StepUp); err != nil {
+			s.log.Warnf("Failed email change re-authentication by user %d", user.ID)
+			return nil, err
+		}
+		if err := s.repo.SetPendingEmail(user, newEmail); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.UpdateUserProfile(user); err != nil {
+		return nil, err
+	}
+
+	if newEmail != "" {
+		s.sendEmailVerification(user)
+	}
+
+	s.log.Infof("Profile of user %d updated", user.ID)
+	return user, nil
+}
+
+// CloseUser closes the authenticated user's profile after re-authenticating them. Users
+// holding funds or owing on credits cannot close. Personal data is anonymised while
+// accounts, credits and transactions are kept as financial records.
+func (s *Service) CloseUser(ctx context.Context, stepUp models.StepUp) error {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.getUserByID(principal.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.verifyStepUp(ctx, user, stepUp); err != nil {
+		s.log.Warnf("Failed closure re-authentication by user %d", user.ID)
+		return err
+	}
+
+	if err := s.repo.CloseUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if principal.TokenID != "" {
+		if err := s.repo.RevokeAccessToken(principal.TokenID, user.ID, time.Now().Add(s.config.AccessTokenTTL)); err != nil {
+			s.log.Errorf("Failed to revoke access token of closed user %d: %v", user.ID, err)
+		}
+	}
+
+	s.log.Infof("User %d closed", user.ID)
+	return nil
+}
+
+// verifyOTP checks a TOTP code, or a recovery code which is then used up. Each TOTP time
+// step is accepted only once.
+func (s *Service) verifyOTP(user *models.User, code string) error {
+	if !user.TwoFactorEnabled {
+		return fmt.Errorf("two-factor authentication not enabled")
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return fmt.Errorf("invalid one-time code")
+	}
+
+	if len(code) == utils.TOTPDigits {
+		secret, err := s.keyring.Decrypt(user.TOTPSecret)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+		}
+		step, ok := utils.VerifyTOTP(secret, code, time.Now())
+		if !ok {
+			return fmt.Errorf("invalid one-time code")
+		}
+		claimed, err := s.repo.ClaimTOTPStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return fmt.Errorf("invalid one-time code")
+		}
+		return nil
+	}
+
+	used, err := s.repo.UseRecoveryCode(user.ID, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return fmt.Errorf("invalid one-time code")
+	}
+	s.log.Warnf("Recovery code used by user %d", user.ID)
+	return nil
+}
+
+// verifyStepUp re-authenticates a user before a sensitive operation: with a one-time code
+// when two-factor authentication is enabled, otherwise with their password. Failures count
+// as failed logins of the user.
+func (s *Service) verifyStepUp(ctx context.Context, user *models.User, stepUp models.StepUp) error {
+	if user.TwoFactorEnabled {
+		if stepUp.OTP == "" {
+			return fmt.Errorf("step-up verification required")
+		}
+		if err := s.checkReauthThrottle(user); err != nil {
+			return err
+		}
+		if err := s.verifyOTP(user, stepUp.OTP); err != nil {
+			s.recordLoginFailure(ctx, user.Email, "", user)
+			return fmt.Errorf("re-authentication failed")
+		}
+	} else {
+		if stepUp.Password == "" {
+			return fmt.Errorf("step-up verification required")
+		}
+		if err := s.verifyPassword(ctx, user, stepUp.Password); err != nil {
+			return err
+		}
+	}
+
+	s.clearLoginFailures(user.Email)
+	return nil
+}
+
+// verifyPassword re-authenticates a user with their password. Failures count as failed
+// logins of the user.
+func (s *Service) verifyPassword(ctx context.Context, user *models.User, password string) error {
+	if err := s.checkReauthThrottle(user); err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		s.recordLoginFailure(ctx, user.Email, "", user)
+		return fmt.Errorf("re-authentication failed")
+	}
+	return nil
+}
+
+// checkReauthThrottle rejects re-authentication while the user's logins are backing off or
+// locked out after failed attempts
+func (s *Service) checkReauthThrottle(user *models.User) error {
+	blockedUntil, err := s.repo.LoginBlockedUntil(loginKey(user.Email), "")
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(blockedUntil) {
+		return fmt.Errorf("too many verification attempts")
+	}
+	return nil
+}
+
+// SetupTOTP starts two-factor enrollment for the authenticated user after re-authenticating
+// them with their password. The secret only takes effect once confirmed with EnableTOTP.
+func (s *Service) SetupTOTP(ctx context.Context, password string) (*models.TOTPEnrollment, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+	if err := s.verifyPassword(ctx, user, password); err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecret, err := s.keyring.Encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+	if err := s.repo.SetPendingTOTPSecret(userID, encryptedSecret); err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Two-factor enrollment started for user %d", userID)
+	return &models.TOTPEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(s.config.TOTPIssuer, user.Email, secret),
+	}, nil
+}
+
+// EnableTOTP confirms two-factor enrollment with a code from the authenticator app and
+// returns single-use recovery codes, which are only shown once
+func (s *Service) EnableTOTP(ctx context.Context, code string) ([]string, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, fmt.Errorf("two-factor enrollment not started")
+	}
+
+	secret, err := s.keyring.Decrypt(user.TOTPSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	step, ok := utils.VerifyTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, fmt.Errorf("invalid one-time code")
+	}
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	codeHashes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		recoveryCodes[i], err = utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codeHashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(recoveryCodes[i]))
+	}
+	if err := s.repo.EnableTOTP(ctx, userID, step, codeHashes); err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Two-factor authentication enabled for user %d", userID)
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns off two-factor authentication after verifying both the password and a
+// one-time code
+func (s *Service) DisableTOTP(ctx context.Context, password, code string) error {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled {
+		return fmt.Errorf("two-factor authentication not enabled")
+	}
+	if err := s.verifyPassword(ctx, user, password); err != nil {
+		return err
+	}
+	if err := s.verifyStepUp(ctx, user, models.StepUp{OTP: code}); err != nil {
+		return err
+	}
+
+	if err := s.repo.DisableTOTP(ctx, userID); err != nil {
+		return err
+	}
+
+	s.log.Infof("Two-factor authentication disabled for user %d", userID)
+	return nil
+}
+
+// issueTokens generates a signed access token and a refresh token for a user. The returned
+// refresh token record is ready to be stored.
+func (s *Service) issueTokens(userID int64, familyID string, amr []string) (*models.TokenPair, *models.RefreshToken, error) {
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.ClosedAt != nil {
+		return nil, nil, fmt.Errorf("user closed")
+	}
+	if user.FrozenAt != nil {
+		return nil, nil, fmt.Errorf("user frozen")
+	}
+	jti, err := utils.GenerateToken(16)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	accessExpiresAt := now.Add(s.config.AccessTokenTTL)
+
+	// Generate JWT
+	accessToken, err := s.tokens.NewAccessToken(userID, user.Role, amr, jti, now, s.config.AccessTokenTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens := &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.config.AccessTokenTTL / time.Second),
+	}
+	record := &models.RefreshToken{
+		UserID:          userID,
+		FamilyID:        familyID,
+		TokenHash:       utils.HashToken(refreshToken),
+		AccessJTI:       jti,
+		AccessExpiresAt: accessExpiresAt,
+		AMR:             amr,
+		ExpiresAt:       now.Add(s.config.RefreshTokenTTL),
+	}
+	return tokens, record, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a rotated refresh token
+func (s *Service) RefreshToken(ctx context.Context, refreshToken, ipAddress string) (*models.TokenPair, error) {
+	if refreshToken == "" {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	var tokens *models.TokenPair
+	var refreshedUserID int64
+	err := s.repo.RotateRefreshToken(ctx, utils.HashToken(refreshToken), ipAddress, func(userID int64, familyID string, amr []string) (*models.RefreshToken, error) {
+		issued, record, err := s.issueTokens(userID, familyID, amr)
+		tokens, refreshedUserID = issued, userID
+		return record, err
+	})
+	if err != nil {
+		if err.Error() == "refresh token reuse detected" {
+			s.log.Warnf("Refresh token reuse detected, token family revoked")
+		}
+		return nil, err
+	}
+
+	s.log.Infof("Tokens refreshed for user %d", refreshedUserID)
+	return tokens, nil
+}
+
+// Logout revokes the access token of the request and the refresh token family it was issued in
+func (s *Service) Logout(ctx context.Context) error {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return err
+	}
+	userID, tokenID := principal.UserID, principal.TokenID
+	if tokenID == "" {
+		return fmt.Errorf("token ID not found in context")
+	}
+
+	if err := s.repo.RevokeTokenFamilyByAccessJTI(tokenID); err != nil {
+		return err
+	}
+	// Access tokens issued outside a token family are denylisted directly
+	if err := s.repo.RevokeAccessToken(tokenID, userID, time.Now().Add(s.config.AccessTokenTTL)); err != nil {
+		return err
+	}
+
+	s.log.Infof("User %d logged out", userID)
+	return nil
+}
+
+// LogoutAll revokes every access and refresh token of the authenticated user
+func (s *Service) LogoutAll(ctx context.Context) error {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return err
+	}
+	userID := principal.UserID
+
+	if err := s.repo.RevokeUserTokens(userID); err != nil {
+		return err
+	}
+	if principal.TokenID != "" {
+		if err := s.repo.RevokeAccessToken(principal.TokenID, userID, time.Now().Add(s.config.AccessTokenTTL)); err != nil {
+			return err
+		}
+	}
+
+	s.log.Infof("User %d logged out of all sessions", userID)
+	return nil
+}
+
+// IsTokenRevoked reports whether an access token has been revoked
+func (s *Service) IsTokenRevoked(jti string) (bool, error) {
+	return s.repo.IsTokenRevoked(jti)
+}
+
+// sessionTouchInterval is how often the last-seen timestamp of a session is updated
+const sessionTouchInterval = time.Minute
+
+// TouchSession records the use of an access token in the session it was issued to
+func (s *Service) TouchSession(jti string) {
+	if err := s.repo.TouchSession(jti, sessionTouchInterval); err != nil {
+		s.log.Errorf("Failed to record session activity: %v", err)
+	}
+}
+
+// maxAPIKeysPerUser limits the number of active API keys of a user
+const maxAPIKeysPerUser = 20
+
+// apiKeyTouchInterval is how often the last-used timestamp of an API key is updated
+const apiKeyTouchInterval = time.Minute
+
+// AuthenticateAPIKey resolves the principal of a personal API key
+func (s *Service) AuthenticateAPIKey(key string) (*auth.Principal, error) {
+	apiKey, err := s.repo.FindAPIKeyByHash(utils.HashToken(key))
+	if err != nil {
+		if err.Error() == "API key not found" {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		return nil, err
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	user, err := s.getUserByID(apiKey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.FrozenAt != nil || user.ClosedAt != nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	if err := s.repo.TouchAPIKey(apiKey.ID, apiKeyTouchInterval); err != nil {
+		s.log.Errorf("Failed to record use of API key %d: %v", apiKey.ID, err)
+	}
+	return &auth.Principal{
+		UserID:      apiKey.UserID,
+		AuthMethods: []string{models.AuthMethodAPIKey},
+		APIKeyID:    apiKey.ID,
+		Scopes:      apiKey.Scopes,
+	}, nil
+}
+
+// CreateAPIKey creates a personal API key with the given scopes for the authenticated user.
+// The returned key is the only time it is available in plain text.
+func (s *Service) CreateAPIKey(ctx context.Context, name string, scopes []string) (*models.APIKey, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.APIKeyID != 0 {
+		return nil, fmt.Errorf("API keys cannot manage API keys")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("API key name must be between 1 and 100 characters")
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	var keyScopes []string
+	for _, scope := range scopes {
+		if !models.ValidAPIKeyScope(scope) {
+			return nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+		if !slices.Contains(keyScopes, scope) {
+			keyScopes = append(keyScopes, scope)
+		}
+	}
+
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	key := "bk_" + secret
+	apiKey := &models.APIKey{
+		UserID:  principal.UserID,
+		Name:    name,
+		Prefix:  key[:11],
+		KeyHash: utils.HashToken(key),
+		Scopes:  keyScopes,
+	}
+	if err := s.repo.CreateAPIKey(ctx, apiKey, maxAPIKeysPerUser); err != nil {
+		return nil, err
+	}
+	apiKey.Key = key
+
+	s.log.Infof("API key %d with scopes %v created by user %d", apiKey.ID, keyScopes, principal.UserID)
+	return apiKey, nil
+}
+
+// ListAPIKeys retrieves the API keys of the authenticated user
+func (s *Service) ListAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if principal.APIKeyID != 0 {
+		return nil, fmt.Errorf("API keys cannot manage API keys")
+	}
+	return s.repo.ListAPIKeys(principal.UserID)
+}
+
+// RevokeAPIKey revokes an API key of the authenticated user
+func (s *Service) RevokeAPIKey(ctx context.Context, keyID int64) error {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return err
+	}
+	if principal.APIKeyID != 0 {
+		return fmt.Errorf("API keys cannot manage API keys")
+	}
+
+	if err := s.repo.RevokeAPIKey(principal.UserID, keyID); err != nil {
+		return err
+	}
+
+	s.log.Infof("API key %d revoked by user %d", keyID, principal.UserID)
+	return nil
+}
+
+// deleteExpiredTokens removes expired refresh tokens and revoked access token entries
+func (s *Service) deleteExpiredTokens() {
+	deleted, err := s.repo.DeleteExpiredTokens()
+	if err != nil {
+		s.log.Errorf("Failed to delete expired tokens: %v", err)
+		return
+	}
+	if deleted > 0 {
+		s.log.Infof("Deleted %d expired tokens", deleted)
+	}
+}
+
+// CreateAccount creates a new account for the authenticated user
+func (s *Service) CreateAccount(ctx context.Context, currency string) (*models.Account, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &models.Account{
+		UserID:   userID,
+		Balance:  0.0,
+		Currency: strings.ToUpper(currency),
+	}
+
+	err = s.withAccountNumber(account.Currency, func(number string) error {
+		account.Number = number
+		return s.repo.CreateAccount(account)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Account created for user %d: %s", userID, account.Currency)
+	return account, nil
+}
+
+// maxAccountNumberAttempts bounds retries when a generated account number is already issued
+const maxAccountNumberAttempts = 5
+
+// withAccountNumber generates an account number for a currency and stores it with the given
+// function, retrying with a new number if the generated one is already issued
+func (s *Service) withAccountNumber(currency string, store func(number string) error) error {
+	for attempt := 1; ; attempt++ {
+		number, err := utils.GenerateAccountNumber(s.config.BankBIC, s.config.AccountBalanceNumber, currency, s.config.AccountBranchCode)
+		if err != nil {
+			return err
+		}
+
+		err = store(number)
+		if err != nil && err.Error() == "account number already issued" && attempt < maxAccountNumberAttempts {
+			s.log.Warnf("Generated account number collided with an issued account, retrying (attempt %d)", attempt)
+			continue
+		}
+		return err
+	}
+}
+
+// PreviewTransferRecipient resolves the recipient of a transfer from one of the authenticated
+// user's accounts without moving funds, so the sender can confirm the masked name. Every
+// preview is recorded, and users are limited in how many they may make.
+func (s *Service) PreviewTransferRecipient(ctx context.Context, from models.AccountRef, to models.TransferRecipient, ipAddress, userAgent string) (*models.RecipientPreview, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireVerifiedEmail(userID); err != nil {
+		return nil, err
+	}
+	fromAccountID, err := s.resolveAccount(from)
+	if err != nil {
+		return nil, err
+	}
+	fromAccount, err := s.GetAccount(ctx, fromAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	lookups, err := s.repo.CountRecipientLookups(userID, time.Now().Add(-s.config.RecipientLookupSpan))
+	if err != nil {
+		return nil, err
+	}
+	if lookups >= s.config.RecipientLookupLimit {
+		s.log.Warnf("Recipient lookups of user %d rate limited after %d lookups", userID, lookups)
+		return nil, fmt.Errorf("too many recipient lookups")
+	}
+
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	lookup := &models.RecipientLookup{
+		UserID:        userID,
+		RecipientType: recipientType(to),
+		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
+	}
+	toAccount, resolveErr := s.resolveRecipient(to, fromAccount.Currency)
+	if resolveErr == nil {
+		lookup.AccountID = &toAccount.ID
+	}
+	// Lookups that find no recipient are recorded too, as they count towards the limit
+	if err := s.repo.CreateRecipientLookup(lookup); err != nil {
+		return nil, err
+	}
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+
+	recipient, err := s.getUserByID(toAccount.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &models.RecipientPreview{
+		Name:     maskName(recipient.FullName, recipient.Username),
+		Currency: toAccount.Currency,
+	}
+	if len(toAccount.Number) > 4 {
+		preview.AccountNumber = strings.Repeat("*", len(toAccount.Number)-4) + toAccount.Number[len(toAccount.Number)-4:]
+	}
+	return preview, nil
+}
+
+// recipientType returns how a transfer recipient is identified
+func recipientType(to models.TransferRecipient) string {
+	switch {
+	case to.Email != "":
+		return models.RecipientTypeEmail
+	case to.CardNumber != "":
+		return models.RecipientTypeCard
+	default:
+		return models.RecipientTypeAccount
+	}
+}
+
+// resolveRecipient returns the account receiving a transfer. Recipients given by email
+// receive into their primary account in the transfer currency; recipients given by card
+// number receive into the card's account. Whichever way it is given, the account must be
+// active and in the transfer currency.
+func (s *Service) resolveRecipient(to models.TransferRecipient, currency string) (*models.Account, error) {
+	given := 0
+	for _, set := range []bool{to.ID != 0 || to.Number != "", to.Email != "", to.CardNumber != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return nil, fmt.Errorf("specify exactly one of recipient account, email or card number")
+	}
+
+	switch {
+	case to.Email != "":
+		user, err := s.repo.FindUserByEmail(strings.TrimSpace(to.Email))
+		if err != nil {
+			if err.Error() == "user not found" {
+				return nil, fmt.Errorf("recipient not found")
+			}
+			return nil, err
+		}
+		return s.primaryRecipientAccount(user, currency)
+	case to.CardNumber != "":
+		cardNumber := strings.NewReplacer(" ", "", "-", "").Replace(to.CardNumber)
+		if !utils.ValidLuhn(cardNumber) {
+			return nil, fmt.Errorf("invalid card number")
+		}
+		// The keyed fingerprint finds the card without decrypting stored card numbers
+		card, err := s.repo.FindCardByFingerprint(utils.PANFingerprint(cardNumber, s.config.PANFingerprintKey))
+		if err != nil {
+			if err.Error() == "card not found" {
+				return nil, fmt.Errorf("recipient not found")
+			}
+			return nil, err
+		}
+		if card.Status != models.CardStatusActive {
+			return nil, fmt.Errorf("recipient not found")
+		}
+		return s.recipientAccount(card.AccountID, currency)
+	}
+
+	accountID, err := s.resolveAccount(to.AccountRef)
+	if err != nil {
+		return nil, err
+	}
+	return s.recipientAccount(accountID, currency)
+}
+
+// recipientAccount returns an account given directly or through a card as a transfer
+// recipient, provided it is active and in the transfer currency
+func (s *Service) recipientAccount(accountID int64, currency string) (*models.Account, error) {
+	account, err := s.repo.GetAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Status != models.AccountStatusActive || !strings.EqualFold(account.Currency, currency) {
+		return nil, fmt.Errorf("recipient has no active %s account", currency)
+	}
+	return account, nil
+}
+
+// primaryRecipientAccount returns the account receiving transfers addressed to a user. Only
+// users with a verified email address can be addressed.
+func (s *Service) primaryRecipientAccount(user *models.User, currency string) (*models.Account, error) {
+	if user.EmailVerifiedAt == nil || user.ClosedAt != nil {
+		return nil, fmt.Errorf("recipient not found")
+	}
+	account, err := s.repo.FindPrimaryAccount(user.ID, currency)
+	if err != nil {
+		if err.Error() == "account not found" {
+			return nil, fmt.Errorf("recipient has no active %s account", currency)
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// maskName reduces a recipient's name to what a sender sees before confirming a transfer:
+// the first name and the initial of the last name, or the first letter of a single-word
+// name or of the username
+func maskName(fullName, username string) string {
+	parts := strings.Fields(fullName)
+	switch len(parts) {
+	case 0:
+		return maskWord(username)
+	case 1:
+		return maskWord(parts[0])
+	default:
+		return parts[0] + " " + string([]rune(parts[len(parts)-1])[0]) + "."
+	}
+}
+
+// maskWord keeps only the first letter of a word
+func maskWord(word string) string {
+	runes := []rune(word)
+	if len(runes) == 0 {
+		return ""
+	}
+	return string(runes[0]) + "***"
+}
+
+// accountLabel identifies an account in transaction descriptions by its account number, or
+// by its ID until a number is backfilled
+func accountLabel(account *models.Account) string {
+	if account.Number != "" {
+		return account.Number
+	}
+	return fmt.Sprintf("%d", account.ID)
+}
+
+// resolveAccount returns the ID of an account referenced by ID or by account number. The
+// control key of an account number is checked before it is looked up.
+func (s *Service) resolveAccount(ref models.AccountRef) (int64, error) {
+	if ref.Number == "" {
+		return ref.ID, nil
+	}
+	if ref.ID != 0 {
+		return 0, fmt.Errorf("specify either an account ID or an account number")
+	}
+	if err := utils.ValidateAccountNumber(s.config.BankBIC, ref.Number); err != nil {
+		return 0, err
+	}
+	return s.repo.FindAccountIDByNumber(ref.Number)
+}
+
+// cardValidityYears is how long each type of card is valid for
+var cardValidityYears = map[string]int{
+	models.CardTypePhysical:  3,
+	models.CardTypeVirtual:   1,
+	models.CardTypeSingleUse: 1,
+}
+
+// CreateCard creates a new card of the given type for the specified account. Virtual and
+// single-use cards may carry a spend cap, after which they are closed.
+func (s *Service) CreateCard(ctx context.Context, accountID int64, paymentSystem, cardType string, spendCap *float64) (*models.Card, error) {
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+	if err := s.requireActiveAccount(accountID); err != nil {
+		return nil, err
+	}
+
+	if paymentSystem == "" {
+		paymentSystem = s.config.DefaultPaymentSystem
+	}
+	if cardType == "" {
+		cardType = models.CardTypePhysical
+	}
+	if _, ok := cardValidityYears[cardType]; !ok {
+		return nil, fmt.Errorf("unsupported card type: %s", cardType)
+	}
+	if spendCap != nil {
+		if cardType == models.CardTypePhysical {
+			return nil, fmt.Errorf("spend cap is only supported for virtual and single-use cards")
+		}
+		if *spendCap <= 0 || math.Round(*spendCap*100) != *spendCap*100 {
+			return nil, fmt.Errorf("spend cap must be a positive amount with at most 2 decimal places")
+		}
+	}
+
+	// Store card with encrypted fields
+	card, err := s.issueCard(accountID, paymentSystem, cardType, spendCap, s.repo.CreateCard)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("%s card created for account %d", cardType, accountID)
+	return card, nil
+}
+
+// maxCardNumberAttempts bounds retries when a generated card number is already issued
+const maxCardNumberAttempts = 5
+
+// issueCard generates a card and stores it with the given function, retrying with a new
+// card number if the generated one is already issued. The returned card holds the
+// decrypted card number and expiry date for the response.
+func (s *Service) issueCard(accountID int64, paymentSystem, cardType string, spendCap *float64, store func(*models.Card) error) (*models.Card, error) {
+	for attempt := 1; ; attempt++ {
+		card, cardNumber, expiryDate, err := s.generateCard(accountID, paymentSystem, cardType)
+		if err != nil {
+			return nil, err
+		}
+		card.SpendCap = spendCap
+
+		err = store(card)
+		if err != nil && err.Error() == "card number already issued" && attempt < maxCardNumberAttempts {
+			s.log.Warnf("Generated card number collided with an issued card, retrying (attempt %d)", attempt)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		card.CardNumber = cardNumber
+		card.ExpiryDate = expiryDate
+		return card, nil
+	}
+}
+
+// randomBIN picks a BIN from the configured ranges of a payment system
+func (s *Service) randomBIN(paymentSystem string) (string, error) {
+	ranges := s.config.CardBINRanges[paymentSystem]
+	if len(ranges) == 0 {
+		return "", fmt.Errorf("unsupported payment system: %s", paymentSystem)
+	}
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(len(ranges))))
+	if err != nil {
+		return "", fmt.Errorf("failed to pick BIN range: %w", err)
+	}
+	binRange := ranges[index.Int64()]
+	return utils.RandomBIN(binRange.Low, binRange.High)
+}
+
+// generateCard generates new card details of the given type for an account. The returned card
+// holds encrypted fields ready to be stored, along with the plaintext card number and expiry date.
+func (s *Service) generateCard(accountID int64, paymentSystem, cardType string) (*models.Card, string, string, error) {
+	bin, err := s.randomBIN(paymentSystem)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// Generate card details
+	cardNumber, err := utils.GenerateCardNumber(bin, 16)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to generate card number: %w", err)
+	}
+	expiryDate := utils.GenerateExpiryDate(cardValidityYears[cardType])
+	cvv, err := utils.GenerateCVV()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	validUntil, err := utils.ParseExpiryDate(expiryDate)
+	if err != nil {
+		return nil, "", "", err
+	}
+	expiresOn := validUntil.AddDate(0, 0, -1)
+
+	// Encrypt card number and expiry date
+	encryptedCardNumber, err := s.keyring.Encrypt(cardNumber)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to encrypt card number: %w", err)
+	}
+	encryptedExpiryDate, err := s.keyring.Encrypt(expiryDate)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to encrypt expiry date: %w", err)
+	}
+
+	// Hash CVV
+	cvvHash, err := bcrypt.GenerateFromPassword([]byte(cvv), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to hash CVV: %w", err)
+	}
+
+	// Generate HMAC
+	hmac := utils.GenerateHMAC(cardNumber, expiryDate, cvv, s.config.HMACSecret)
+
+	card := &models.Card{
+		AccountID:  accountID,
+		CardNumber: encryptedCardNumber,
+		ExpiryDate: encryptedExpiryDate,
+		CVV:        string(cvvHash),
+		HMAC:       hmac,
+		Status:     models.CardStatusActive,
+		ExpiresOn:  &expiresOn,
+
+		PaymentSystem:  paymentSystem,
+		CardType:       cardType,
+		PANFingerprint: utils.PANFingerprint(cardNumber, s.config.PANFingerprintKey),
+		MaskedPAN:      utils.MaskPAN(cardNumber),
+	}
+	return card, cardNumber, expiryDate, nil
+}
+
+// CreateCredit creates a new credit with payment schedule
+func (s *Service) CreateCredit(ctx context.Context, accountID int64, amount float64, termMonths int) (*models.Credit, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.requireVerifiedEmail(userID); err != nil {
+		return nil, err
+	}
+
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+	if err := s.requireActiveAccount(accountID); err != nil {
+		return nil, err
+	}
+
+	// Validate input
+	if amount <= 0 {
+		return nil, fmt.Errorf("credit amount must be positive")
+	}
+	if termMonths <= 0 || termMonths > 360 {
+		return nil, fmt.Errorf("term must be between 1 and 360 months")
+	}
+
+	// Get interest rate from CBR
+	interestRate, err := s.cbrClient.GetKeyRate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interest rate: %w", err)
+	}
+
+	// Generate HMAC for credit
+	hmac := utils.GenerateHMAC(
+		fmt.Sprintf("%d", userID),
+		fmt.Sprintf("%d", accountID),
+		fmt.Sprintf("%.2f", amount),
+		s.config.HMACSecret,
+	)
+
+	credit := &models.Credit{
+		UserID:       userID,
 		AccountID:    accountID,
 		Amount:       amount,
 		InterestRate: interestRate,
@@ -561,362 +2323,1112 @@ func (s *Service) CreateCredit(ctx context.Context, accountID int64, amount floa
 		HMAC:         hmac,
 	}
 
-	// Create credit
-	if err := s.repo.CreateCredit(credit); err != nil {
+	// Create credit
+	if err := s.repo.CreateCredit(credit); err != nil {
+		return nil, err
+	}
+
+	// Generate and save payment schedule
+	payments, err := s.generatePaymentSchedule(credit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate payment schedule: %w", err)
+	}
+	for _, payment := range payments {
+		if err := s.repo.CreatePaymentSchedule(payment); err != nil {
+			return nil, fmt.Errorf("failed to save payment schedule: %w", err)
+		}
+	}
+
+	s.log.Infof("Credit created for account %d, amount %.2f, term %d months, rate %.2f%%", accountID, amount, termMonths, interestRate)
+	return credit, nil
+}
+
+// ListPaymentSchedules retrieves the payment schedule for a credit
+func (s *Service) ListPaymentSchedules(ctx context.Context, creditID int64) ([]*models.PaymentSchedule, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify credit belongs to user
+	credit, err := s.repo.FindCreditByID(creditID)
+	if err != nil {
+		return nil, fmt.Errorf("credit not found: %w", err)
+	}
+	if !canAccessUser(principal, credit.UserID) {
+		return nil, fmt.Errorf("credit does not belong to user")
+	}
+
+	payments, err := s.repo.ListPaymentSchedules(creditID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Retrieved %d payment schedules for credit %d", len(payments), creditID)
+	return payments, nil
+}
+
+// Deposit adds funds to an account
+func (s *Service) Deposit(ctx context.Context, accountID int64, amount float64) (*models.Transaction, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.requireVerifiedEmail(userID); err != nil {
+		return nil, err
+	}
+
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	// Validate amount
+	if amount <= 0 {
+		return nil, fmt.Errorf("deposit amount must be positive")
+	}
+
+	transaction := &models.Transaction{
+		AccountID:   accountID,
+		Amount:      amount,
+		Type:        "deposit",
+		Description: "Deposit to account",
+	}
+
+	if err := s.repo.Deposit(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	// Get updated balance
+	balance, err := s.repo.GetAccountBalance(accountID)
+	if err != nil {
+		s.log.Errorf("Failed to get balance for account %d after deposit: %v", accountID, err)
+		return transaction, nil // Continue without sending email if balance fetch fails
+	}
+
+	// Get user for email
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		s.log.Errorf("Failed to find user %d for deposit notification: %v", userID, err)
+		return transaction, nil // Continue without sending email if user fetch fails
+	}
+
+	// Send deposit notification
+	if err := s.emailSender.SendTransactionNotification(
+		user.Email,
+		user.Username,
+		accountID,
+		amount,
+		"Deposit",
+		balance,
+	); err != nil {
+		s.log.Errorf("Failed to send deposit notification for account %d: %v", accountID, err)
+	}
+
+	s.log.Infof("Deposit of %f to account %d", amount, accountID)
+	return transaction, nil
+}
+
+// Withdraw removes funds from an account
+func (s *Service) Withdraw(ctx context.Context, accountID int64, amount float64) (*models.Transaction, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.requireVerifiedEmail(userID); err != nil {
+		return nil, err
+	}
+
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	// Validate amount
+	if amount <= 0 {
+		return nil, fmt.Errorf("withdrawal amount must be positive")
+	}
+
+	// The available balance is checked when the withdrawal is posted
+	transaction := &models.Transaction{
+		AccountID:   accountID,
+		Amount:      -amount, // Negative for withdrawal
+		Type:        "withdrawal",
+		Description: "Withdrawal from account",
+	}
+
+	if err := s.repo.Withdraw(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	// Get updated balance
+	balance, err := s.repo.GetAccountBalance(accountID)
+	if err != nil {
+		s.log.Errorf("Failed to get balance for account %d after withdrawal: %v", accountID, err)
+		return transaction, nil // Continue without sending email if balance fetch fails
+	}
+
+	// Get user for email
+	user, err := s.getUserByID(userID)
+	if err != nil {
+		s.log.Errorf("Failed to find user %d for withdrawal notification: %v", userID, err)
+		return transaction, nil // Continue without sending email if user fetch fails
+	}
+
+	// Send withdrawal notification
+	if err := s.emailSender.SendTransactionNotification(
+		user.Email,
+		user.Username,
+		accountID,
+		amount,
+		"Withdrawal",
+		balance,
+	); err != nil {
+		s.log.Errorf("Failed to send withdrawal notification for account %d: %v", accountID, err)
+	}
+
+	s.log.Infof("Withdrawal of %f from account %d", amount, accountID)
+	return transaction, nil
+}
+
+// Transfer moves funds from an account referenced by ID or account number to a recipient.
+// Transfers above the configured step-up limit require re-authentication.
+func (s *Service) Transfer(ctx context.Context, from models.AccountRef, to models.TransferRecipient, amount float64, stepUp models.StepUp) ([]*models.Transaction, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fromAccountID, err := s.resolveAccount(from)
+	if err != nil {
 		return nil, err
 	}
 
-	// Generate and save payment schedule
-	payments, err := s.generatePaymentSchedule(credit)
+	if err := s.requireVerifiedEmail(userID); err != nil {
+		return nil, err
+	}
+
+	// Verify from_account belongs to user
+	fromAccount, err := s.GetAccount(ctx, fromAccountID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate payment schedule: %w", err)
+		return nil, err
 	}
-	for _, payment := range payments {
-		if err := s.repo.CreatePaymentSchedule(payment); err != nil {
-			return nil, fmt.Errorf("failed to save payment schedule: %w", err)
+
+	toAccount, err := s.resolveRecipient(to, fromAccount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	toAccountID := toAccount.ID
+
+	// Validate amount
+	if amount <= 0 {
+		return nil, fmt.Errorf("transfer amount must be positive")
+	}
+	if amount > s.config.StepUpTransferLimit {
+		user, err := s.getUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.verifyStepUp(ctx, user, stepUp); err != nil {
+			s.log.Warnf("Failed step-up verification for transfer of %f by user %d", amount, userID)
+			return nil, err
+		}
+	}
+
+	// Create transactions; the available balance is checked when they are posted
+	withdrawal := &models.Transaction{
+		AccountID:   fromAccountID,
+		Amount:      -amount,
+		Type:        "transfer_out",
+		Description: fmt.Sprintf("Transfer to account %s", accountLabel(toAccount)),
+	}
+	deposit := &models.Transaction{
+		AccountID:   toAccountID,
+		Amount:      amount,
+		Type:        "transfer_in",
+		Description: fmt.Sprintf("Transfer from account %s", accountLabel(fromAccount)),
+	}
+
+	if err := s.repo.Transfer(ctx, withdrawal, deposit); err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Transfer of %f from account %d to account %d", amount, fromAccountID, toAccountID)
+	return []*models.Transaction{withdrawal, deposit}, nil
+}
+
+// ListTransactions retrieves a list of transactions for an account
+func (s *Service) ListTransactions(ctx context.Context, accountID int64, transactionType string, limit, offset int) ([]*models.Transaction, error) {
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	// Validate pagination
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	transactions, err := s.repo.ListTransactions(accountID, transactionType, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Retrieved %d transactions for account %d", len(transactions), accountID)
+	return transactions, nil
+}
+
+// ListCards retrieves a list of cards for a user or specific account
+func (s *Service) ListCards(ctx context.Context, accountID int64, limit, offset int) ([]*models.Card, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate pagination
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	// If account_id is specified, verify it belongs to user
+	if accountID != 0 {
+		if err := s.authorizeAccount(ctx, accountID); err != nil {
+			return nil, err
+		}
+	}
+
+	cards, err := s.repo.ListCards(userID, accountID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Listings expose only the masked PAN
+	for _, card := range cards {
+		card.CardNumber = ""
+		card.ExpiryDate = ""
+	}
+
+	s.log.Infof("Retrieved %d cards for user %d", len(cards), userID)
+	return cards, nil
+}
+
+// ReverseTransaction creates compensating transactions for an erroneous transaction
+func (s *Service) ReverseTransaction(ctx context.Context, transactionID int64, reason string) ([]*models.Transaction, error) {
+	adminID, err := s.requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := s.repo.FindTransactionByID(transactionID)
+	if err != nil {
+		return nil, err
+	}
+	switch original.Type {
+	case "deposit", "withdrawal", "transfer_in", "transfer_out":
+	default:
+		return nil, fmt.Errorf("transactions of type %s cannot be reversed", original.Type)
+	}
+
+	description := fmt.Sprintf("Reversal of transaction %d", transactionID)
+	if reason != "" {
+		description += ": " + reason
+	}
+
+	reversals, err := s.repo.ReverseTransaction(ctx, transactionID, description)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Transaction %d reversed by admin %d with %d compensating transactions", transactionID, adminID, len(reversals))
+	return reversals, nil
+}
+
+// ListAccounts retrieves all accounts of the authenticated user
+func (s *Service) ListAccounts(ctx context.Context) ([]*models.Account, error) {
+	userID, err := authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := s.repo.ListAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Retrieved %d accounts for user %d", len(accounts), userID)
+	return accounts, nil
+}
+
+// GetAccountBalance retrieves the ledger and available balances of an account
+func (s *Service) GetAccountBalance(ctx context.Context, accountID int64) (*models.Account, error) {
+	return s.GetAccount(ctx, accountID)
+}
+
+// GetAccount retrieves an account of the authenticated user
+func (s *Service) GetAccount(ctx context.Context, accountID int64) (*models.Account, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.repo.GetAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccessUser(principal, account.UserID) {
+		return nil, fmt.Errorf("account does not belong to user")
+	}
+
+	return account, nil
+}
+
+// CloseAccount closes an account of the authenticated user. A remaining balance is swept to
+// another of their accounts in the same currency when sweepToID is set, otherwise the
+// balance must be zero.
+func (s *Service) CloseAccount(ctx context.Context, accountID, sweepToID int64) ([]*models.Transaction, error) {
+	account, err := s.GetAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if sweepToID != 0 {
+		if sweepToID == accountID {
+			return nil, fmt.Errorf("cannot sweep an account into itself")
+		}
+		target, err := s.GetAccount(ctx, sweepToID)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(target.Currency, account.Currency) {
+			return nil, fmt.Errorf("accounts have different currencies")
 		}
 	}
 
-	s.log.Infof("Credit created for account %d, amount %.2f, term %d months, rate %.2f%%", accountID, amount, termMonths, interestRate)
-	return credit, nil
+	sweep, err := s.repo.CloseAccount(ctx, accountID, sweepToID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sweep) > 0 {
+		s.log.Infof("Account %d closed by user %d, balance of %f swept to account %d", accountID, account.UserID, -sweep[0].Amount, sweepToID)
+	} else {
+		s.log.Infof("Account %d closed by user %d", accountID, account.UserID)
+	}
+	return sweep, nil
+}
+
+// SetAccountFrozen freezes or unfreezes an account, stopping all money movement on it
+func (s *Service) SetAccountFrozen(ctx context.Context, accountID int64, frozen bool) error {
+	staffID, err := s.requireRole(ctx, models.RoleOperator, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.SetAccountFrozen(accountID, frozen); err != nil {
+		return err
+	}
+	if frozen {
+		s.log.Warnf("Account %d frozen by staff user %d", accountID, staffID)
+	} else {
+		s.log.Infof("Account %d unfrozen by staff user %d", accountID, staffID)
+	}
+	return nil
+}
+
+// CreateHold reserves funds on an account without posting a transaction
+func (s *Service) CreateHold(ctx context.Context, accountID int64, amount float64, description string) (*models.Hold, error) {
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	// Validate amount
+	if amount <= 0 {
+		return nil, fmt.Errorf("hold amount must be positive")
+	}
+	if description == "" {
+		description = "Authorization hold"
+	}
+
+	hold := &models.Hold{
+		AccountID:   accountID,
+		Amount:      amount,
+		Description: description,
+		ExpiresAt:   time.Now().Add(s.config.HoldTTL),
+	}
+
+	if err := s.repo.CreateHold(ctx, hold); err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Hold %d of %f placed on account %d", hold.ID, amount, accountID)
+	return hold, nil
+}
+
+// findUserHold retrieves a hold the authenticated user placed on one of their accounts.
+// Holds placed by card authorizations are settled only by the processing network.
+func (s *Service) findUserHold(ctx context.Context, holdID int64) (*models.Hold, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hold, err := s.repo.FindHoldByID(holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := s.canAccessAccount(principal, hold.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, fmt.Errorf("hold not found")
+	}
+	if hold.Origin != models.HoldOriginCustomer {
+		return nil, fmt.Errorf("hold is settled by the card network")
+	}
+	return hold, nil
+}
+
+// CaptureHold posts a transaction for all or part of a hold. A zero amount captures the full hold.
+func (s *Service) CaptureHold(ctx context.Context, holdID int64, amount float64) (*models.Hold, error) {
+	hold, err := s.findUserHold(ctx, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount == 0 {
+		amount = hold.Amount
+	}
+	if amount < 0 {
+		return nil, fmt.Errorf("capture amount must be positive")
+	}
+
+	transaction := &models.Transaction{
+		Type:        "hold_capture",
+		Description: fmt.Sprintf("Capture of hold %d: %s", hold.ID, hold.Description),
+	}
+	hold, err = s.repo.CaptureHold(ctx, holdID, amount, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Hold %d captured for %f on account %d", hold.ID, amount, hold.AccountID)
+	return hold, nil
+}
+
+// ReleaseHold releases a pending hold, restoring the available balance
+func (s *Service) ReleaseHold(ctx context.Context, holdID int64) (*models.Hold, error) {
+	if _, err := s.findUserHold(ctx, holdID); err != nil {
+		return nil, err
+	}
+
+	hold, err := s.repo.ReleaseHold(ctx, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Hold %d released on account %d", hold.ID, hold.AccountID)
+	return hold, nil
+}
+
+// ListHolds retrieves holds for an account
+func (s *Service) ListHolds(ctx context.Context, accountID int64, status string, limit, offset int) ([]*models.Hold, error) {
+	// Verify account belongs to user
+	if err := s.authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	// Validate pagination
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	holds, err := s.repo.ListHolds(accountID, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infof("Retrieved %d holds for account %d", len(holds), accountID)
+	return holds, nil
 }
 
-// ListPaymentSchedules retrieves the payment schedule for a credit
-func (s *Service) ListPaymentSchedules(ctx context.Context, creditID int64) ([]*models.PaymentSchedule, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// verifyPresentedCard matches card details presented by the processing network against
+// the stored card: the PAN fingerprint locates the card, then the encrypted PAN and expiry
+// date, the bcrypt CVV hash and the HMAC are checked. It returns a non-approved response
+// code on failure. Mismatches of the PAN, expiry date and CVV are all reported as an invalid
+// card so that callers cannot probe the fields one at a time.
+func (s *Service) verifyPresentedCard(req *models.CardAuthorizationRequest) (*models.Card, string, string) {
+	if !utils.ValidLuhn(req.PAN) {
+		return nil, models.ResponseInvalidCard, "invalid card"
 	}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	card, err := s.repo.FindCardByFingerprint(utils.PANFingerprint(req.PAN, s.config.PANFingerprintKey))
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		if err.Error() == "card not found" {
+			return nil, models.ResponseInvalidCard, "invalid card"
+		}
+		s.log.Errorf("Failed to look up card for authorization: %v", err)
+		return nil, models.ResponseSystemError, "system error"
 	}
 
-	// Verify credit belongs to user
-	credit, err := s.repo.FindCreditByID(creditID)
+	cardNumber, err := s.keyring.Decrypt(card.CardNumber)
 	if err != nil {
-		return nil, fmt.Errorf("credit not found: %w", err)
+		s.log.Errorf("Failed to decrypt card number for card ID %d: %v", card.ID, err)
+		return nil, models.ResponseSystemError, "system error"
 	}
-	if credit.UserID != userID {
-		return nil, fmt.Errorf("credit does not belong to user")
+	expiryDate, err := s.keyring.Decrypt(card.ExpiryDate)
+	if err != nil {
+		s.log.Errorf("Failed to decrypt expiry date for card ID %d: %v", card.ID, err)
+		return nil, models.ResponseSystemError, "system error"
+	}
+	if subtle.ConstantTimeCompare([]byte(cardNumber), []byte(req.PAN)) != 1 {
+		s.log.Warnf("Card authorization PAN mismatch for card ID %d", card.ID)
+		return nil, models.ResponseInvalidCard, "invalid card"
+	}
+	if subtle.ConstantTimeCompare([]byte(expiryDate), []byte(req.Expiry)) != 1 {
+		s.log.Warnf("Card authorization expiry date mismatch for card ID %d", card.ID)
+		return card, models.ResponseInvalidCard, "invalid card"
+	}
+	if bcrypt.CompareHashAndPassword([]byte(card.CVV), []byte(req.CVV)) != nil {
+		s.log.Warnf("Card authorization CVV mismatch for card ID %d", card.ID)
+		return card, models.ResponseInvalidCard, "invalid card"
+	}
+	hmac := utils.GenerateHMAC(req.PAN, req.Expiry, req.CVV, s.config.HMACSecret)
+	if subtle.ConstantTimeCompare([]byte(hmac), []byte(card.HMAC)) != 1 {
+		s.log.Errorf("HMAC mismatch for card ID %d", card.ID)
+		return card, models.ResponseDoNotHonor, "card verification failed"
 	}
 
-	payments, err := s.repo.ListPaymentSchedules(creditID)
+	validUntil, err := utils.ParseExpiryDate(expiryDate)
 	if err != nil {
-		return nil, err
+		s.log.Errorf("Invalid stored expiry date for card ID %d: %v", card.ID, err)
+		return nil, models.ResponseSystemError, "system error"
+	}
+	if !time.Now().Before(validUntil) {
+		return card, models.ResponseExpiredCard, "card expired"
 	}
 
-	s.log.Infof("Retrieved %d payment schedules for credit %d", len(payments), creditID)
-	return payments, nil
+	switch card.Status {
+	case models.CardStatusActive:
+	case models.CardStatusTemporarilyBlocked:
+		return card, models.ResponseRestrictedCard, "card blocked"
+	case models.CardStatusLostStolen:
+		return card, models.ResponseLostCard, "card reported lost or stolen"
+	case models.CardStatusPINBlocked:
+		return card, models.ResponsePINTriesExceeded, "PIN tries exceeded"
+	case models.CardStatusExpired:
+		return card, models.ResponseExpiredCard, "card expired"
+	default:
+		return card, models.ResponseInvalidCard, "card closed"
+	}
+
+	return card, models.ResponseApproved, ""
 }
 
-// Deposit adds funds to an account
-func (s *Service) Deposit(ctx context.Context, accountID int64, amount float64) (*models.Transaction, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// verifyCardPIN checks a PIN presented with a card authorization, counting wrong attempts
+// towards blocking the card. It returns a non-approved response code on failure.
+func (s *Service) verifyCardPIN(card *models.Card, pin string) (string, string) {
+	if card.PINHash == "" {
+		return models.ResponseIncorrectPIN, "PIN not set"
+	}
+	if bcrypt.CompareHashAndPassword([]byte(card.PINHash), []byte(pin)) != nil {
+		blocked, err := s.repo.RecordCardPINFailure(card.ID, s.config.CardPINMaxAttempts)
+		if err != nil {
+			s.log.Errorf("Failed to record wrong PIN for card %d: %v", card.ID, err)
+			return models.ResponseSystemError, "system error"
+		}
+		if blocked {
+			s.log.Warnf("Card %d blocked after too many wrong PIN attempts", card.ID)
+			return models.ResponsePINTriesExceeded, "PIN tries exceeded"
+		}
+		return models.ResponseIncorrectPIN, "incorrect PIN"
 	}
+	if err := s.repo.ResetCardPINAttempts(card.ID); err != nil {
+		s.log.Errorf("Failed to reset wrong PIN attempts for card %d: %v", card.ID, err)
+	}
+	return models.ResponseApproved, ""
+}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+// isMCC reports whether a merchant category code is empty or four digits
+func isMCC(mcc string) bool {
+	if mcc == "" {
+		return true
+	}
+	if len(mcc) != 4 {
+		return false
+	}
+	for _, c := range mcc {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
+	return true
+}
 
-	// Verify account belongs to user
-	accountUserID, err := s.repo.FindAccountByID(accountID)
-	if err != nil {
-		return nil, err
+// declineCardPayment records a declined card authorization
+func (s *Service) declineCardPayment(auth *models.CardAuthorization, responseCode, message string) *models.CardAuthorization {
+	auth.ResponseCode = responseCode
+	auth.Approved = false
+	auth.Message = message
+	if err := s.repo.CreateCardAuthorization(auth); err != nil {
+		s.log.Errorf("Failed to record declined card authorization: %v", err)
 	}
-	if accountUserID != userID {
-		return nil, fmt.Errorf("account does not belong to user")
+	s.log.Warnf("Card authorization declined with code %s: %s", responseCode, message)
+	return auth
+}
+
+// AuthorizeCardPayment verifies a card presented by the processing network and debits,
+// or places a hold on, its account. Declines are reported through the ISO 8583 response
+// code rather than as errors.
+func (s *Service) AuthorizeCardPayment(ctx context.Context, req *models.CardAuthorizationRequest) (*models.CardAuthorization, error) {
+	auth := &models.CardAuthorization{
+		Amount:       req.Amount,
+		Currency:     strings.ToUpper(req.Currency),
+		MerchantID:   req.MerchantID,
+		MerchantName: req.MerchantName,
+		MCC:          req.MCC,
+		Channel:      strings.ToLower(req.Channel),
+	}
+	if auth.Channel == "" {
+		auth.Channel = models.CardChannelPOS
 	}
 
 	// Validate amount
-	if amount <= 0 {
-		return nil, fmt.Errorf("deposit amount must be positive")
+	if req.Amount <= 0 || math.Round(req.Amount*100) != req.Amount*100 {
+		return s.declineCardPayment(auth, models.ResponseInvalidAmount, "invalid amount"), nil
+	}
+	if !isMCC(req.MCC) || len(req.MerchantID) > 50 || len(req.MerchantName) > 255 {
+		auth.MCC, auth.MerchantID, auth.MerchantName = "", "", ""
+		return s.declineCardPayment(auth, models.ResponseInvalidMerchant, "invalid merchant"), nil
+	}
+	switch auth.Channel {
+	case models.CardChannelPOS, models.CardChannelOnline, models.CardChannelATM, models.CardChannelContactless:
+	default:
+		auth.Channel = ""
+		return s.declineCardPayment(auth, models.ResponseNotPermitted, "unsupported channel"), nil
 	}
 
-	transaction := &models.Transaction{
-		AccountID:   accountID,
-		Amount:      amount,
-		Type:        "deposit",
-		Description: "Deposit to account",
+	card, responseCode, message := s.verifyPresentedCard(req)
+	if card != nil {
+		auth.CardID = &card.ID
+	}
+	if responseCode != models.ResponseApproved {
+		return s.declineCardPayment(auth, responseCode, message), nil
 	}
 
-	if err := s.repo.Deposit(ctx, transaction); err != nil {
-		return nil, err
+	// ATM withdrawals always require the PIN; other channels verify it when presented
+	if req.PIN != "" || auth.Channel == models.CardChannelATM {
+		if responseCode, message := s.verifyCardPIN(card, req.PIN); responseCode != models.ResponseApproved {
+			return s.declineCardPayment(auth, responseCode, message), nil
+		}
 	}
 
-	// Get updated balance
-	balance, err := s.repo.GetAccountBalance(accountID)
+	account, err := s.repo.GetAccount(card.AccountID)
 	if err != nil {
-		s.log.Errorf("Failed to get balance for account %d after deposit: %v", accountID, err)
-		return transaction, nil // Continue without sending email if balance fetch fails
+		s.log.Errorf("Failed to find account %d for card %d: %v", card.AccountID, card.ID, err)
+		return s.declineCardPayment(auth, models.ResponseSystemError, "system error"), nil
+	}
+	if !strings.EqualFold(account.Currency, auth.Currency) {
+		return s.declineCardPayment(auth, models.ResponseNotPermitted, "currency not supported"), nil
 	}
 
-	// Get user for email
-	user, err := s.getUserByID(userID)
+	authCode, err := utils.GenerateAuthCode()
 	if err != nil {
-		s.log.Errorf("Failed to find user %d for deposit notification: %v", userID, err)
-		return transaction, nil // Continue without sending email if user fetch fails
+		return nil, err
 	}
+	auth.AuthCode = authCode
+	auth.ResponseCode = models.ResponseApproved
+	auth.Approved = true
+	auth.Message = "approved"
 
-	// Send deposit notification
-	if err := s.emailSender.SendTransactionNotification(
-		user.Email,
-		user.Username,
-		accountID,
-		amount,
-		"Deposit",
-		balance,
-	); err != nil {
-		s.log.Errorf("Failed to send deposit notification for account %d: %v", accountID, err)
+	err = s.repo.AuthorizeCardPayment(ctx, auth, account.ID, req.Capture, time.Now().Add(s.config.HoldTTL))
+	if err != nil {
+		auth.AuthCode = ""
+		switch err.Error() {
+		case "insufficient funds":
+			return s.declineCardPayment(auth, models.ResponseInsufficientFunds, "insufficient funds"), nil
+		case "card limit exceeded":
+			return s.declineCardPayment(auth, models.ResponseExceedsLimit, "card limit exceeded"), nil
+		case "card is not active":
+			return s.declineCardPayment(auth, models.ResponseInvalidCard, "card closed"), nil
+		case "card channel disabled", "merchant category blocked", "account frozen", "account closed":
+			return s.declineCardPayment(auth, models.ResponseNotPermitted, err.Error()), nil
+		case "user frozen", "user closed":
+			return s.declineCardPayment(auth, models.ResponseRestrictedCard, "card owner restricted"), nil
+		}
+		s.log.Errorf("Failed to authorize card payment for card %d: %v", card.ID, err)
+		return s.declineCardPayment(auth, models.ResponseSystemError, "system error"), nil
 	}
 
-	s.log.Infof("Deposit of %f to account %d", amount, accountID)
-	return transaction, nil
+	s.log.Infof("Card payment of %f approved for card %d at merchant %s, auth code %s", auth.Amount, card.ID, auth.MerchantID, auth.AuthCode)
+	return auth, nil
 }
 
-// Withdraw removes funds from an account
-func (s *Service) Withdraw(ctx context.Context, accountID int64, amount float64) (*models.Transaction, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// CaptureCardPayment settles the hold of an approved card authorization for the amount
+// cleared by the processing network. A zero amount captures the authorized amount.
+func (s *Service) CaptureCardPayment(ctx context.Context, authID int64, amount float64) (*models.CardAuthorization, error) {
+	if amount < 0 || math.Round(amount*100) != amount*100 {
+		return nil, fmt.Errorf("capture amount must be a positive amount with at most 2 decimal places")
 	}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	transaction := &models.Transaction{Type: "card_payment"}
+	auth, err := s.repo.CaptureCardPayment(ctx, authID, amount, transaction)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, err
 	}
 
-	// Verify account belongs to user
-	accountUserID, err := s.repo.FindAccountByID(accountID)
+	s.log.Infof("Card payment %d captured for %f", auth.ID, -transaction.Amount)
+	return auth, nil
+}
+
+// ReverseCardPayment cancels an approved card authorization at the request of the
+// processing network, releasing its hold or refunding the captured payment
+func (s *Service) ReverseCardPayment(ctx context.Context, authID int64) (*models.CardAuthorization, error) {
+	description := fmt.Sprintf("Reversal of card payment %d", authID)
+	auth, err := s.repo.ReverseCardPayment(ctx, authID, description)
 	if err != nil {
 		return nil, err
 	}
-	if accountUserID != userID {
-		return nil, fmt.Errorf("account does not belong to user")
+
+	s.log.Infof("Card payment %d reversed", auth.ID)
+	return auth, nil
+}
+
+// cardStatusTransitions lists the statuses a card may be moved to by its owner. A card blocked
+// by wrong PINs is only unblocked by setting a new PIN.
+var cardStatusTransitions = map[string][]string{
+	models.CardStatusActive:             {models.CardStatusTemporarilyBlocked, models.CardStatusLostStolen, models.CardStatusClosed},
+	models.CardStatusTemporarilyBlocked: {models.CardStatusActive, models.CardStatusLostStolen, models.CardStatusClosed},
+	models.CardStatusLostStolen:         {models.CardStatusClosed},
+	models.CardStatusPINBlocked:         {models.CardStatusLostStolen, models.CardStatusClosed},
+	models.CardStatusExpired:            {models.CardStatusClosed},
+}
+
+// findUserCard retrieves a card and verifies its account belongs to the authenticated user
+func (s *Service) findUserCard(ctx context.Context, cardID int64) (*models.Card, int64, error) {
+	principal, err := requirePrincipal(ctx)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Validate amount
-	if amount <= 0 {
-		return nil, fmt.Errorf("withdrawal amount must be positive")
+	card, err := s.repo.FindCardByID(cardID)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Check balance
-	balance, err := s.repo.GetAccountBalance(accountID)
+	allowed, err := s.canAccessAccount(principal, card.AccountID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !allowed {
+		return nil, 0, fmt.Errorf("card not found")
+	}
+	return card, principal.UserID, nil
+}
+
+// UpdateCardStatus moves a card to a new lifecycle status
+func (s *Service) UpdateCardStatus(ctx context.Context, cardID int64, status string) (*models.Card, error) {
+	card, userID, err := s.findUserCard(ctx, cardID)
 	if err != nil {
 		return nil, err
 	}
-	if balance < amount {
-		return nil, fmt.Errorf("insufficient funds")
+
+	allowed := false
+	for _, next := range cardStatusTransitions[card.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("cannot change card status from %s to %s", card.Status, status)
 	}
 
-	transaction := &models.Transaction{
-		AccountID:   accountID,
-		Amount:      -amount, // Negative for withdrawal
-		Type:        "withdrawal",
-		Description: "Withdrawal from account",
+	if err := s.repo.UpdateCardStatus(cardID, card.Status, status); err != nil {
+		return nil, err
 	}
 
-	if err := s.repo.Withdraw(ctx, transaction); err != nil {
+	s.log.Infof("Card %d status changed from %s to %s by user %d", cardID, card.Status, status, userID)
+	card.Status = status
+	card.CardNumber = ""
+	card.ExpiryDate = ""
+	return card, nil
+}
+
+// ReissueCard closes a card and issues a replacement with a new number, expiry date and CVV
+func (s *Service) ReissueCard(ctx context.Context, cardID int64) (*models.Card, error) {
+	card, userID, err := s.findUserCard(ctx, cardID)
+	if err != nil {
 		return nil, err
 	}
+	if card.Status == models.CardStatusClosed {
+		return nil, fmt.Errorf("closed cards cannot be reissued")
+	}
 
-	// Get updated balance
-	balance, err = s.repo.GetAccountBalance(accountID)
+	newCard, err := s.issueCard(card.AccountID, card.PaymentSystem, card.CardType, card.SpendCap, func(newCard *models.Card) error {
+		return s.repo.ReissueCard(ctx, cardID, newCard)
+	})
 	if err != nil {
-		s.log.Errorf("Failed to get balance for account %d after withdrawal: %v", accountID, err)
-		return transaction, nil // Continue without sending email if balance fetch fails
+		return nil, err
 	}
 
-	// Get user for email
-	user, err := s.getUserByID(userID)
+	s.log.Infof("Card %d reissued as card %d by user %d", cardID, newCard.ID, userID)
+	return newCard, nil
+}
+
+// reencryptionBatchSize is the number of cards re-encrypted between progress updates
+const reencryptionBatchSize = 100
+
+// reencryptionStaleAfter is how long a running re-encryption job may go without progress
+// before it is considered dead and a new job may start
+const reencryptionStaleAfter = 10 * time.Minute
+
+// StartCardReencryption starts a background job rotating all stored card data to the active encryption key
+func (s *Service) StartCardReencryption(ctx context.Context) (*models.ReencryptionJob, error) {
+	adminID, err := s.requireAdmin(ctx)
 	if err != nil {
-		s.log.Errorf("Failed to find user %d for withdrawal notification: %v", userID, err)
-		return transaction, nil // Continue without sending email if user fetch fails
+		return nil, err
 	}
 
-	// Send withdrawal notification
-	if err := s.emailSender.SendTransactionNotification(
-		user.Email,
-		user.Username,
-		accountID,
-		amount,
-		"Withdrawal",
-		balance,
-	); err != nil {
-		s.log.Errorf("Failed to send withdrawal notification for account %d: %v", accountID, err)
+	job := &models.ReencryptionJob{
+		KeyVersion: s.keyring.ActiveVersion(),
+		StartedBy:  adminID,
+	}
+	if err := s.repo.CreateReencryptionJob(job, reencryptionStaleAfter); err != nil {
+		return nil, err
 	}
 
-	s.log.Infof("Withdrawal of %f from account %d", amount, accountID)
-	return transaction, nil
+	go s.runCardReencryption(job)
+
+	s.log.Infof("Card re-encryption job %d to key version %d started by admin %d", job.ID, job.KeyVersion, adminID)
+	return job, nil
 }
 
-// Transfer moves funds between accounts
-func (s *Service) Transfer(ctx context.Context, fromAccountID, toAccountID int64, amount float64) ([]*models.Transaction, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// GetCardReencryption retrieves the progress of a re-encryption job
+func (s *Service) GetCardReencryption(ctx context.Context, jobID int64) (*models.ReencryptionJob, error) {
+	if _, err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.repo.FindReencryptionJobByID(jobID)
+}
+
+// runCardReencryption re-encrypts every card not yet encrypted under the active key
+func (s *Service) runCardReencryption(job *models.ReencryptionJob) {
+	prefix := fmt.Sprintf("v%d:", job.KeyVersion)
+	var lastID int64
+	for {
+		cards, err := s.repo.ListCardsNotEncryptedWith(prefix, lastID, reencryptionBatchSize)
+		if err != nil {
+			job.Status = models.ReencryptionJobFailed
+			job.Error = err.Error()
+			break
+		}
+		if len(cards) == 0 {
+			job.Status = models.ReencryptionJobCompleted
+			break
+		}
+
+		for _, card := range cards {
+			lastID = card.ID
+			if err := s.reencryptCard(card); err != nil {
+				s.log.Errorf("Failed to re-encrypt card ID %d: %v", card.ID, err)
+				job.Failed++
+				continue
+			}
+			job.Processed++
+		}
+
+		if err := s.repo.UpdateReencryptionJob(job); err != nil {
+			s.log.Errorf("Failed to record progress of re-encryption job %d: %v", job.ID, err)
+		}
+	}
+
+	if err := s.repo.UpdateReencryptionJob(job); err != nil {
+		s.log.Errorf("Failed to record outcome of re-encryption job %d: %v", job.ID, err)
 	}
+	s.log.Infof("Card re-encryption job %d %s: %d cards re-encrypted, %d failed", job.ID, job.Status, job.Processed, job.Failed)
+}
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+// reencryptCard re-encrypts the card number and expiry date of a card under the active key
+func (s *Service) reencryptCard(card *models.Card) error {
+	cardNumber, err := s.keyring.Decrypt(card.CardNumber)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt card number: %w", err)
+	}
+	expiryDate, err := s.keyring.Decrypt(card.ExpiryDate)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return fmt.Errorf("failed to decrypt expiry date: %w", err)
 	}
 
-	// Verify from_account belongs to user
-	fromAccountUserID, err := s.repo.FindAccountByID(fromAccountID)
+	encryptedCardNumber, err := s.keyring.Encrypt(cardNumber)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to encrypt card number: %w", err)
 	}
-	if fromAccountUserID != userID {
-		return nil, fmt.Errorf("from account does not belong to user")
+	encryptedExpiryDate, err := s.keyring.Encrypt(expiryDate)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt expiry date: %w", err)
 	}
 
-	// Verify to_account exists
-	_, err = s.repo.FindAccountByID(toAccountID)
+	return s.repo.UpdateCardCiphertexts(card.ID, card.CardNumber, card.ExpiryDate, encryptedCardNumber, encryptedExpiryDate)
+}
+
+// RevealCard returns the full card number and expiry date of a card after step-up
+// verification of the user, and records an audit entry of the reveal
+func (s *Service) RevealCard(ctx context.Context, cardID int64, stepUp models.StepUp, ipAddress, userAgent string) (*models.Card, error) {
+	card, userID, err := s.findUserCard(ctx, cardID)
 	if err != nil {
 		return nil, err
 	}
-
-	// Validate amount
-	if amount <= 0 {
-		return nil, fmt.Errorf("transfer amount must be positive")
+	if card.Status == models.CardStatusClosed {
+		return nil, fmt.Errorf("card is closed")
 	}
 
-	// Check balance
-	balance, err := s.repo.GetAccountBalance(fromAccountID)
+	user, err := s.getUserByID(userID)
 	if err != nil {
 		return nil, err
 	}
-	if balance < amount {
-		return nil, fmt.Errorf("insufficient funds")
+	if err := s.verifyStepUp(ctx, user, stepUp); err != nil {
+		s.log.Warnf("Failed card reveal re-authentication for card %d by user %d", cardID, userID)
+		return nil, err
 	}
 
-	// Create transactions
-	withdrawal := &models.Transaction{
-		AccountID:   fromAccountID,
-		Amount:      -amount,
-		Type:        "transfer_out",
-		Description: fmt.Sprintf("Transfer to account %d", toAccountID),
+	cardNumber, err := s.keyring.Decrypt(card.CardNumber)
+	if err != nil {
+		s.log.Errorf("Failed to decrypt card number for card ID %d: %v", card.ID, err)
+		return nil, fmt.Errorf("failed to decrypt card number for card ID %d: %w", card.ID, err)
 	}
-	deposit := &models.Transaction{
-		AccountID:   toAccountID,
-		Amount:      amount,
-		Type:        "transfer_in",
-		Description: fmt.Sprintf("Transfer from account %d", fromAccountID),
+	expiryDate, err := s.keyring.Decrypt(card.ExpiryDate)
+	if err != nil {
+		s.log.Errorf("Failed to decrypt expiry date for card ID %d: %v", card.ID, err)
+		return nil, fmt.Errorf("failed to decrypt expiry date for card ID %d: %w", card.ID, err)
 	}
 
-	if err := s.repo.Transfer(ctx, withdrawal, deposit); err != nil {
+	// Details are only returned once the reveal has been audited
+	reveal := &models.CardReveal{
+		CardID:    cardID,
+		UserID:    userID,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+	}
+	if err := s.repo.CreateCardReveal(reveal); err != nil {
 		return nil, err
 	}
 
-	s.log.Infof("Transfer of %f from account %d to account %d", amount, fromAccountID, toAccountID)
-	return []*models.Transaction{withdrawal, deposit}, nil
+	card.CardNumber = cardNumber
+	card.ExpiryDate = expiryDate
+	s.log.Infof("Card %d revealed to user %d from %s", cardID, userID, ipAddress)
+	return card, nil
 }
 
-// ListTransactions retrieves a list of transactions for an account
-func (s *Service) ListTransactions(ctx context.Context, accountID int64, transactionType string, limit, offset int) ([]*models.Transaction, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
-	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+// GetCardLimits retrieves the spending limits and current usage of a card
+func (s *Service) GetCardLimits(ctx context.Context, cardID int64) (*models.CardLimits, error) {
+	if _, _, err := s.findUserCard(ctx, cardID); err != nil {
+		return nil, err
 	}
+	return s.repo.GetCardLimits(cardID)
+}
 
-	// Verify account belongs to user
-	accountUserID, err := s.repo.FindAccountByID(accountID)
+// SetCardLimits replaces the spending limits and usage controls of a card
+func (s *Service) SetCardLimits(ctx context.Context, limits *models.CardLimits) (*models.CardLimits, error) {
+	card, userID, err := s.findUserCard(ctx, limits.CardID)
 	if err != nil {
 		return nil, err
 	}
-	if accountUserID != userID {
-		return nil, fmt.Errorf("account does not belong to user")
+	if card.Status == models.CardStatusClosed {
+		return nil, fmt.Errorf("card is closed")
 	}
 
-	// Validate pagination
-	if limit <= 0 {
-		limit = 10 // Default limit
+	// Validate limits
+	for _, limit := range []*float64{limits.DailyLimit, limits.MonthlyLimit, limits.PerTransactionLimit} {
+		if limit != nil && (*limit < 0 || math.Round(*limit*100) != *limit*100) {
+			return nil, fmt.Errorf("limits must be non-negative amounts with at most 2 decimal places")
+		}
 	}
-	if offset < 0 {
-		offset = 0
+	if limits.BlockedMCCs == nil {
+		limits.BlockedMCCs = []string{}
+	}
+	for _, mcc := range limits.BlockedMCCs {
+		if mcc == "" || !isMCC(mcc) {
+			return nil, fmt.Errorf("invalid merchant category code: %q", mcc)
+		}
 	}
 
-	transactions, err := s.repo.ListTransactions(accountID, transactionType, limit, offset)
-	if err != nil {
+	if err := s.repo.SetCardLimits(limits); err != nil {
 		return nil, err
 	}
 
-	s.log.Infof("Retrieved %d transactions for account %d", len(transactions), accountID)
-	return transactions, nil
+	s.log.Infof("Limits of card %d updated by user %d", limits.CardID, userID)
+	return limits, nil
 }
 
-// ListCards retrieves a list of cards for a user or specific account
-func (s *Service) ListCards(ctx context.Context, accountID int64, limit, offset int) ([]*models.Card, error) {
-	userIDStr, ok := ctx.Value("userID").(string)
-	if !ok || userIDStr == "" {
-		return nil, fmt.Errorf("user ID not found in context")
+// isPIN reports whether a PIN is four digits
+func isPIN(pin string) bool {
+	if len(pin) != 4 {
+		return false
 	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
+	return true
+}
 
-	// Validate pagination
-	if limit <= 0 {
-		limit = 10 // Default limit
+// SetCardPIN sets or changes the PIN of a card after re-authenticating the user with their
+// password. Setting a new PIN unblocks a card blocked by wrong PINs, which also requires a
+// one-time code when two-factor authentication is enabled.
+func (s *Service) SetCardPIN(ctx context.Context, cardID int64, pin, password, otp string) error {
+	card, userID, err := s.findUserCard(ctx, cardID)
+	if err != nil {
+		return err
 	}
-	if offset < 0 {
-		offset = 0
+	switch card.Status {
+	case models.CardStatusActive, models.CardStatusTemporarilyBlocked, models.CardStatusPINBlocked:
+	default:
+		return fmt.Errorf("cannot set PIN of a card with status %s", card.Status)
 	}
-
-	// If account_id is specified, verify it belongs to user
-	if accountID != 0 {
-		accountUserID, err := s.repo.FindAccountByID(accountID)
-		if err != nil {
-			return nil, err
-		}
-		if accountUserID != userID {
-			return nil, fmt.Errorf("account does not belong to user")
-		}
+	if !isPIN(pin) {
+		return fmt.Errorf("PIN must be 4 digits")
 	}
 
-	cards, err := s.repo.ListCards(userID, accountID, limit, offset)
+	user, err := s.getUserByID(userID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := s.verifyPassword(ctx, user, password); err != nil {
+		s.log.Warnf("Failed PIN change re-authentication for card %d by user %d", cardID, userID)
+		return err
+	}
+	if card.Status == models.CardStatusPINBlocked && user.TwoFactorEnabled {
+		if err := s.verifyStepUp(ctx, user, models.StepUp{OTP: otp}); err != nil {
+			s.log.Warnf("Failed step-up verification to unblock card %d by user %d", cardID, userID)
+			return err
+		}
 	}
 
-	// Decode encryption key from hex
-	encryptionKey, err := hex.DecodeString(s.config.EncryptionKey)
+	pinHash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+		return fmt.Errorf("failed to hash PIN: %w", err)
 	}
-	if len(encryptionKey) != 32 {
-		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(encryptionKey))
+	if err := s.repo.SetCardPIN(cardID, string(pinHash)); err != nil {
+		return err
 	}
 
-	// Decrypt card_number and expiry_date
-	for _, card := range cards {
-		s.log.Debugf("Decrypting card ID %d: card_number=%s, expiry_date=%s", card.ID, card.CardNumber, card.ExpiryDate)
-		decryptedCardNumber, err := utils.Decrypt(card.CardNumber, encryptionKey)
-		if err != nil {
-			s.log.Errorf("Failed to decrypt card number for card ID %d: %v", card.ID, err)
-			return nil, fmt.Errorf("failed to decrypt card number for card ID %d: %w", card.ID, err)
-		}
-		decryptedExpiryDate, err := utils.Decrypt(card.ExpiryDate, encryptionKey)
-		if err != nil {
-			s.log.Errorf("Failed to decrypt expiry date for card ID %d: %v", card.ID, err)
-			return nil, fmt.Errorf("failed to decrypt expiry date for card ID %d: %w", card.ID, err)
-		}
-		card.CardNumber = decryptedCardNumber
-		card.ExpiryDate = decryptedExpiryDate
+	if card.Status == models.CardStatusPINBlocked {
+		s.log.Infof("PIN of card %d reset and card unblocked by user %d", cardID, userID)
+		return nil
 	}
-
-	s.log.Infof("Retrieved %d cards for user %d", len(cards), userID)
-	return cards, nil
+	s.log.Infof("PIN of card %d set by user %d", cardID, userID)
+	return nil
 }
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// Correspondent accounts are keyed with "0" and the fifth and sixth BIC digits instead of the
+// last three digits of the BIC, so published bank requisites check the key algorithm itself
+func TestAccountKeySumPublishedAccounts(t *testing.T) {
+	tests := []struct {
+		bank    string
+		bic     string
+		account string
+	}{
+		{"Sberbank", "044525225", "30101810400000000225"},
+		{"VTB", "044525187", "30101810700000000187"},
+		{"Alfa-Bank", "044525593", "30101810200000000593"},
+		{"T-Bank", "044525974", "30101810145250000974"},
+		{"Sberbank North-West", "044030653", "30101810500000000653"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bank, func(t *testing.T) {
+			if sum := accountKeySum("0"+tt.bic[4:6], tt.account); sum%10 != 0 {
+				t.Errorf("accountKeySum(%s, %s) = %d, want a multiple of 10", tt.bic, tt.account, sum)
+			}
+		})
+	}
+}
+
+func TestGenerateAccountNumber(t *testing.T) {
+	tests := []struct {
+		currency string
+		code     string
+	}{
+		{"RUB", "810"},
+		{"usd", "840"},
+		{"EUR", "978"},
+		{"CNY", "156"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			number, err := GenerateAccountNumber("044525999", "40817", tt.currency, "0001")
+			if err != nil {
+				t.Fatalf("GenerateAccountNumber() error = %v", err)
+			}
+			if len(number) != 20 || !isDigits(number) {
+				t.Fatalf("GenerateAccountNumber() = %q, want 20 digits", number)
+			}
+			if !strings.HasPrefix(number, "40817"+tt.code) || number[9:13] != "0001" {
+				t.Errorf("GenerateAccountNumber() = %q, want balance account 40817, currency %s and branch 0001", number, tt.code)
+			}
+			if err := ValidateAccountNumber("044525999", number); err != nil {
+				t.Errorf("ValidateAccountNumber(%q) error = %v", number, err)
+			}
+			if err := ValidateAccountNumber("044525998", number); err == nil {
+				t.Errorf("ValidateAccountNumber(%q) accepted the number for another BIC", number)
+			}
+
+			// Every weight is coprime to 10, so any single changed digit breaks the key
+			for i := range number {
+				mutated := []byte(number)
+				mutated[i] = '0' + (mutated[i]-'0'+1)%10
+				if err := ValidateAccountNumber("044525999", string(mutated)); err == nil {
+					t.Errorf("ValidateAccountNumber(%q) accepted a changed digit at position %d", mutated, i)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateAccountNumberUnsupportedCurrency(t *testing.T) {
+	if _, err := GenerateAccountNumber("044525999", "40817", "XYZ", "0001"); err == nil {
+		t.Error("GenerateAccountNumber() accepted an unsupported currency")
+	}
+}
+
+func TestValidateAccountNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  string
+		wantErr string
+	}{
+		{"too short", "4081781000000000000", "account number must be 20 digits"},
+		{"too long", "408178100000000000000", "account number must be 20 digits"},
+		{"not digits", "40817810O00000000000", "account number must be 20 digits"},
+		{"empty", "", "account number must be 20 digits"},
+		{"wrong control key", "40817810000000000001", "invalid account number control key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAccountNumber("044525999", tt.number)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ValidateAccountNumber(%q) error = %v, want %q", tt.number, err, tt.wantErr)
+			}
+		})
+	}
+}
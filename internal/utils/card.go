@@ -8,32 +8,30 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"strings"
 	"time"
 )
 
-// GenerateCardNumber generates a card number with the specified prefix and length
-func GenerateCardNumber(prefix string, length int) (string, error) {
-	if length < len(prefix) || length > 19 {
+// GenerateCardNumber generates a card number of the given length starting with the BIN,
+// filled with uniformly random digits and ending with a Luhn check digit
+func GenerateCardNumber(bin string, length int) (string, error) {
+	if length <= len(bin) || length < 12 || length > 19 {
 		return "", fmt.Errorf("invalid card number length: %d", length)
 	}
 
-	// Generate random digits
-	digits := make([]byte, length-len(prefix))
-	_, err := rand.Read(digits)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate random digits: %w", err)
-	}
-
-	// Convert to string and ensure valid digits
 	var builder strings.Builder
-	builder.WriteString(prefix)
-	for _, b := range digits {
-		digit := b%10 + '0' // Convert to ASCII digit
+	builder.WriteString(bin)
+	for builder.Len() < length-1 {
+		digit, err := randomDigit()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random digits: %w", err)
+		}
 		builder.WriteByte(digit)
 	}
 
-	cardNumber := builder.String()
+	partial := builder.String()
+	cardNumber := partial + string(LuhnCheckDigit(partial))
 
 	// Ensure length is exact
 	if len(cardNumber) != length {
@@ -43,104 +41,156 @@ func GenerateCardNumber(prefix string, length int) (string, error) {
 	return cardNumber, nil
 }
 
-// GenerateExpiryDate generates a card expiry date (MM/YY)
-func GenerateExpiryDate() string {
-	now := time.Now()
-	year := now.Year() + 3 // Cards valid for 3 years
-	month := now.Month()
-	return fmt.Sprintf("%02d/%02d", month, year%100)
-}
-
-// GenerateCVV generates a 3-digit CVV code
-func GenerateCVV() string {
-	b := make([]byte, 3)
-	rand.Read(b)
-	return fmt.Sprintf("%03d", (int(b[0])%10)*100+(int(b[1])%10)*10+int(b[2])%10)
-}
-
-// GenerateHMAC generates an HMAC for card details
-func GenerateHMAC(cardNumber, expiryDate, cvv, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	data := cardNumber + expiryDate + cvv
-	h.Write([]byte(data))
-	return hex.EncodeToString(h.Sum(nil))
+// randomDigit returns a uniformly distributed ASCII digit
+func randomDigit() (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10))
+	if err != nil {
+		return 0, err
+	}
+	return byte('0' + n.Int64()), nil
 }
 
-// Encrypt encrypts a string using AES with PKCS#5/PKCS#7 padding
-func Encrypt(data string, key []byte) (string, error) {
-	if len(data) == 0 {
-		return "", fmt.Errorf("input data is empty")
+// RandomBIN returns a uniformly random BIN from an inclusive range of equal-length prefixes
+func RandomBIN(low, high string) (string, error) {
+	lowValue, ok := new(big.Int).SetString(low, 10)
+	if !ok {
+		return "", fmt.Errorf("invalid BIN: %s", low)
 	}
-	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return "", fmt.Errorf("encryption key must be 16, 24, or 32 bytes, got %d", len(key))
+	highValue, ok := new(big.Int).SetString(high, 10)
+	if !ok || len(low) != len(high) || highValue.Cmp(lowValue) < 0 {
+		return "", fmt.Errorf("invalid BIN range: %s-%s", low, high)
 	}
 
-	block, err := aes.NewCipher(key)
+	size := new(big.Int).Sub(highValue, lowValue)
+	size.Add(size, big.NewInt(1))
+	offset, err := rand.Int(rand.Reader, size)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return "", fmt.Errorf("failed to pick BIN: %w", err)
 	}
+	return fmt.Sprintf("%0*s", len(low), offset.Add(offset, lowValue).String()), nil
+}
 
-	// Generate IV
-	iv := make([]byte, aes.BlockSize)
-	_, err = rand.Read(iv)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate IV: %w", err)
+// LuhnCheckDigit computes the Luhn check digit to append to a partial card number
+func LuhnCheckDigit(partial string) byte {
+	sum := 0
+	double := true // The digit next to the check digit is doubled
+	for i := len(partial) - 1; i >= 0; i-- {
+		digit := int(partial[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// ValidLuhn reports whether a card number consists of digits and has a valid Luhn check digit
+func ValidLuhn(cardNumber string) bool {
+	if len(cardNumber) < 12 || len(cardNumber) > 19 {
+		return false
 	}
+	for _, c := range cardNumber {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	last := len(cardNumber) - 1
+	return LuhnCheckDigit(cardNumber[:last]) == cardNumber[last]
+}
 
-	// Add PKCS#5/PKCS#7 padding
-	dataBytes := []byte(data)
-	padding := aes.BlockSize - len(dataBytes)%aes.BlockSize
-	for i := 0; i < padding; i++ {
-		dataBytes = append(dataBytes, byte(padding))
+// MaskPAN masks all but the first 6 and last 4 digits of a card number
+func MaskPAN(cardNumber string) string {
+	if len(cardNumber) < 12 {
+		return strings.Repeat("*", len(cardNumber))
 	}
+	return cardNumber[:6] + strings.Repeat("*", len(cardNumber)-10) + cardNumber[len(cardNumber)-4:]
+}
 
-	// Encrypt
-	ciphertext := make([]byte, len(dataBytes))
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(ciphertext, dataBytes)
+// PANFingerprint computes a keyed fingerprint of a card number, used to look up and
+// deduplicate cards without decrypting them
+func PANFingerprint(cardNumber, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(cardNumber))
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	// Combine IV and ciphertext
-	final := append(iv, ciphertext...)
-	return hex.EncodeToString(final), nil
+// GenerateExpiryDate generates a card expiry date (MM/YY) the given number of years from now
+func GenerateExpiryDate(years int) string {
+	now := time.Now()
+	year := now.Year() + years
+	month := now.Month()
+	return fmt.Sprintf("%02d/%02d", month, year%100)
 }
 
-// Decrypt decrypts a hex-encoded string using AES with PKCS#5/PKCS#7 padding
-func Decrypt(encryptedData string, key []byte) (string, error) {
-	if len(encryptedData) == 0 {
-		return "", fmt.Errorf("encrypted data is empty")
+// GenerateCVV generates a 3-digit CVV code
+func GenerateCVV() (string, error) {
+	cvv := make([]byte, 3)
+	for i := range cvv {
+		digit, err := randomDigit()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate CVV: %w", err)
+		}
+		cvv[i] = digit
 	}
-	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return "", fmt.Errorf("decryption key must be 16, 24, or 32 bytes, got %d", len(key))
+	return string(cvv), nil
+}
+
+// GenerateAuthCode generates a 6-character authorization code
+func GenerateAuthCode() (string, error) {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	b := make([]byte, 6)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate authorization code: %w", err)
+		}
+		b[i] = alphabet[n.Int64()]
 	}
+	return string(b), nil
+}
 
-	// Decode hex
-	data, err := hex.DecodeString(encryptedData)
+// ParseExpiryDate returns the first instant after a card expiry date (MM/YY) stops being valid
+func ParseExpiryDate(expiryDate string) (time.Time, error) {
+	expiry, err := time.Parse("01/06", expiryDate)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode hex: %w", err)
+		return time.Time{}, fmt.Errorf("invalid expiry date: %s", expiryDate)
 	}
+	// Cards are valid through the last day of the expiry month
+	return expiry.AddDate(0, 1, 0), nil
+}
 
-	if len(data) < aes.BlockSize {
-		return "", fmt.Errorf("encrypted data too short: %d bytes", len(data))
-	}
+// GenerateHMAC generates an HMAC for card details
+func GenerateHMAC(cardNumber, expiryDate, cvv, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	data := cardNumber + expiryDate + cvv
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	// Extract IV and ciphertext
-	iv := data[:aes.BlockSize]
-	ciphertext := data[aes.BlockSize:]
+// decryptLegacyCBC decrypts a hex-encoded value written by the former AES-CBC scheme with
+// PKCS#5/PKCS#7 padding. Failures are reported without detail so that callers cannot be
+// turned into a padding oracle.
+func decryptLegacyCBC(encryptedData string, key []byte) (string, error) {
+	errDecrypt := fmt.Errorf("failed to decrypt legacy value")
 
-	if len(ciphertext) == 0 {
-		return "", fmt.Errorf("ciphertext is empty")
-	}
-	if len(ciphertext)%aes.BlockSize != 0 {
-		return "", fmt.Errorf("invalid ciphertext length: %d bytes", len(ciphertext))
+	data, err := hex.DecodeString(encryptedData)
+	if err != nil || len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return "", errDecrypt
 	}
 
-	// Create cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	// Decrypt
+	// Extract IV and ciphertext
+	iv := data[:aes.BlockSize]
+	ciphertext := data[aes.BlockSize:]
+
 	plaintext := make([]byte, len(ciphertext))
 	mode := cipher.NewCBCDecrypter(block, iv)
 	mode.CryptBlocks(plaintext, ciphertext)
@@ -148,12 +198,14 @@ func Decrypt(encryptedData string, key []byte) (string, error) {
 	// Remove PKCS#5/PKCS#7 padding
 	padding := int(plaintext[len(plaintext)-1])
 	if padding > aes.BlockSize || padding == 0 {
-		return "", fmt.Errorf("invalid padding value: %d", padding)
+		return "", errDecrypt
 	}
+	invalid := 0
 	for i := len(plaintext) - padding; i < len(plaintext); i++ {
-		if int(plaintext[i]) != padding {
-			return "", fmt.Errorf("invalid padding bytes: expected %d, got %d at position %d", padding, plaintext[i], i)
-		}
+		invalid |= int(plaintext[i]) ^ padding
+	}
+	if invalid != 0 {
+		return "", errDecrypt
 	}
 
 	return string(plaintext[:len(plaintext)-padding]), nil
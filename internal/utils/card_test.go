@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		partial string
+		want    byte
+	}{
+		{"7992739871", '3'}, // Worked example of the Luhn algorithm
+		{"411111111111111", '1'},
+		{"555555555555444", '4'},
+		{"37828224631000", '5'},
+		{"", '0'},
+	}
+	for _, tt := range tests {
+		if got := LuhnCheckDigit(tt.partial); got != tt.want {
+			t.Errorf("LuhnCheckDigit(%q) = %q, want %q", tt.partial, got, tt.want)
+		}
+	}
+}
+
+func TestValidLuhn(t *testing.T) {
+	tests := []struct {
+		cardNumber string
+		want       bool
+	}{
+		{"4111111111111111", true},
+		{"5555555555554444", true},
+		{"378282246310005", true},
+		{"2200000000000004", true},
+		{"4111111111111112", false},
+		{"4111111111111121", false},
+		{"4111-1111-1111-1111", false},
+		{"79927398713", false}, // Valid check digit, but shorter than a card number
+		{"41111111111111111113", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidLuhn(tt.cardNumber); got != tt.want {
+			t.Errorf("ValidLuhn(%q) = %v, want %v", tt.cardNumber, got, tt.want)
+		}
+	}
+}
+
+func TestRandomBIN(t *testing.T) {
+	tests := []struct {
+		name    string
+		low     string
+		high    string
+		wantErr bool
+	}{
+		{"range", "220000", "220499", false},
+		{"single BIN", "427600", "427600", false},
+		{"leading zeros", "000100", "000199", false},
+		{"eight digits", "22000000", "22009999", false},
+		{"different lengths", "4", "40", true},
+		{"inverted", "499999", "400000", true},
+		{"not digits", "4x0000", "4x9999", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				bin, err := RandomBIN(tt.low, tt.high)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("RandomBIN(%s, %s) = %s, want an error", tt.low, tt.high, bin)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("RandomBIN(%s, %s) error = %v", tt.low, tt.high, err)
+				}
+				// Equal-length digit strings compare like the numbers they represent
+				if len(bin) != len(tt.low) || bin < tt.low || bin > tt.high {
+					t.Fatalf("RandomBIN(%s, %s) = %s, want a BIN in the range", tt.low, tt.high, bin)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateCardNumber(t *testing.T) {
+	tests := []struct {
+		bin     string
+		length  int
+		wantErr bool
+	}{
+		{"220000", 16, false},
+		{"4276", 16, false},
+		{"22000000", 19, false},
+		{"62", 12, false},
+		{"220000", 11, true},
+		{"220000", 20, true},
+		{"220000123456", 12, true},
+	}
+	for _, tt := range tests {
+		cardNumber, err := GenerateCardNumber(tt.bin, tt.length)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("GenerateCardNumber(%s, %d) = %s, want an error", tt.bin, tt.length, cardNumber)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("GenerateCardNumber(%s, %d) error = %v", tt.bin, tt.length, err)
+			continue
+		}
+		if len(cardNumber) != tt.length || !strings.HasPrefix(cardNumber, tt.bin) || !ValidLuhn(cardNumber) {
+			t.Errorf("GenerateCardNumber(%s, %d) = %s, want %d digits starting with the BIN and a valid check digit", tt.bin, tt.length, cardNumber, tt.length)
+		}
+	}
+}
+
+func TestGenerateCVV(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		cvv, err := GenerateCVV()
+		if err != nil {
+			t.Fatalf("GenerateCVV() error = %v", err)
+		}
+		if len(cvv) != 3 || !isDigits(cvv) {
+			t.Fatalf("GenerateCVV() = %q, want 3 digits", cvv)
+		}
+	}
+}
@@ -92,7 +92,7 @@ func (s *Sender) SendTransactionNotification(to, username string, accountID int6
 			"An amount of %.2f RUB has been withdrawn from your account %d.\n"+
 				"Transaction time: %s\n"+
 				"Current balance: %.2f RUB\n",
-			accountID, amount, time.Now().Format("2006-01-02 15:04:05"), balance,
+			amount, accountID, time.Now().Format("2006-01-02 15:04:05"), balance,
 		)
 	}
 	body += "\nBest regards,\nBank Service"
@@ -110,3 +110,153 @@ func (s *Sender) SendTransactionNotification(to, username string, accountID int6
 	s.logger.Infof("Email sent to %s: %s", to, e.Subject)
 	return nil
 }
+
+// send delivers an email through the configured SMTP server
+func (s *Sender) send(e *email.Email) error {
+	addr := fmt.Sprintf("%s:%s", s.cfg.SMTPHost, s.cfg.SMTPPort)
+	auth := smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, s.cfg.SMTPHost)
+	if err := e.Send(addr, auth); err != nil {
+		s.logger.Errorf("Failed to send %q email to %s: %v", e.Subject, e.To, err)
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	s.logger.Infof("Email sent to %s: %s", e.To, e.Subject)
+	return nil
+}
+
+// SendEmailVerification sends a link and token for verifying an email address
+func (s *Sender) SendEmailVerification(to, username, token string, expiresAt time.Time) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "Verify Your Email Address"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"Please confirm your email address by opening the link below:\n%s?token=%s\n\n"+
+			"Or enter this verification code: %s\n\n"+
+			"The link expires on %s. Until your email is verified, deposits, withdrawals, transfers and credits are unavailable.\n",
+		username, s.cfg.EmailVerificationURL, token, token, expiresAt.Format("2006-01-02 15:04 MST"),
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
+
+// SendEmailChangeVerification sends a link and token confirming a requested new email address
+func (s *Sender) SendEmailChangeVerification(to, username, token string, expiresAt time.Time) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "Confirm Your New Email Address"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"Please confirm the new email address of your account by opening the link below:\n%s?token=%s\n\n"+
+			"Or enter this verification code: %s\n\n"+
+			"The link expires on %s. Your current email address stays in use until the new one is confirmed.\n",
+		username, s.cfg.EmailVerificationURL, token, token, expiresAt.Format("2006-01-02 15:04 MST"),
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
+
+// SendPasswordReset sends a one-time link and token for resetting a password
+func (s *Sender) SendPasswordReset(to, username, token string, expiresAt time.Time) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "Password Reset Request"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"A password reset was requested for your account. To choose a new password, open the link below:\n%s?token=%s\n\n"+
+			"The link can be used once and expires on %s.\n"+
+			"If you did not request a password reset, you can ignore this email.\n",
+		username, s.cfg.PasswordResetURL, token, expiresAt.Format("2006-01-02 15:04 MST"),
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
+
+// SendAccountLocked notifies a user that logins to their account were locked after repeated failures
+func (s *Sender) SendAccountLocked(to, username string, lockedUntil time.Time) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "Your Account Was Temporarily Locked"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"Logins to your account were locked until %s after too many failed attempts.\n"+
+			"If these attempts were not made by you, we recommend changing your password and enabling two-factor authentication.\n",
+		username, lockedUntil.Format("2006-01-02 15:04 MST"),
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
+
+// SendNewDeviceLogin notifies a user of a login from a device not seen before
+func (s *Sender) SendNewDeviceLogin(to, username, ipAddress, userAgent string, at time.Time) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "New Sign-in to Your Account"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"Your account was signed in to from a new device on %s.\n"+
+			"IP address: %s\nDevice: %s\n\n"+
+			"If this was not you, sign out the session, change your password and contact support.\n",
+		username, at.Format("2006-01-02 15:04 MST"), ipAddress, userAgent,
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
+
+// SendPasswordChanged notifies a user that their password was changed
+func (s *Sender) SendPasswordChanged(to, username string) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "Your Password Was Changed"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"The password of your account was changed on %s. All sessions were signed out and all API keys were revoked.\n"+
+			"If you did not make this change, reset your password immediately and contact support.\n",
+		username, time.Now().Format("2006-01-02 15:04:05"),
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
+
+// SendEmailChanged notifies a user at their previous address that their email address was changed
+func (s *Sender) SendEmailChanged(to, username, newEmail string) error {
+	e := email.NewEmail()
+	e.From = s.cfg.SenderEmail
+	e.To = []string{to}
+	e.Subject = "Your Email Address Was Changed"
+
+	body := fmt.Sprintf(
+		"Dear %s,\n\n"+
+			"The email address of your account was changed to %s on %s.\n"+
+			"If you did not make this change, contact support immediately.\n",
+		username, newEmail, time.Now().Format("2006-01-02 15:04:05"),
+	)
+	body += "\nBest regards,\nBank Service"
+	e.Text = []byte(body)
+
+	return s.send(e)
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Keyring encrypts values with AES-GCM under its active key and decrypts values written
+// under any of its keys, including legacy AES-CBC values.
+//
+// Encrypted values use the envelope "v<version>:<hex(nonce || ciphertext || tag)>", where
+// the envelope prefix is bound to the ciphertext as additional authenticated data. Values
+// without an envelope are legacy AES-CBC ciphertexts under the legacy key.
+type Keyring struct {
+	keys          map[int]cipher.AEAD
+	activeVersion int
+	legacyKey     []byte
+}
+
+// NewKeyring creates a keyring from 32-byte keys indexed by version
+func NewKeyring(keys map[int][]byte, activeVersion int, legacyKey []byte) (*Keyring, error) {
+	k := &Keyring{
+		keys:          make(map[int]cipher.AEAD, len(keys)),
+		activeVersion: activeVersion,
+		legacyKey:     legacyKey,
+	}
+	for version, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key version %d must be 32 bytes, got %d", version, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key version %d: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM for key version %d: %w", version, err)
+		}
+		k.keys[version] = aead
+	}
+	if _, ok := k.keys[activeVersion]; !ok {
+		return nil, fmt.Errorf("active encryption key version %d is not configured", activeVersion)
+	}
+	if len(legacyKey) != 0 && len(legacyKey) != 16 && len(legacyKey) != 24 && len(legacyKey) != 32 {
+		return nil, fmt.Errorf("legacy encryption key must be 16, 24, or 32 bytes, got %d", len(legacyKey))
+	}
+	return k, nil
+}
+
+// ActiveVersion returns the version of the key used for encryption
+func (k *Keyring) ActiveVersion() int {
+	return k.activeVersion
+}
+
+// envelopePrefix returns the envelope prefix for a key version
+func envelopePrefix(version int) string {
+	return "v" + strconv.Itoa(version) + ":"
+}
+
+// Encrypt encrypts a string with the active key
+func (k *Keyring) Encrypt(data string) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("input data is empty")
+	}
+
+	aead := k.keys[k.activeVersion]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	prefix := envelopePrefix(k.activeVersion)
+	sealed := aead.Seal(nonce, nonce, []byte(data), []byte(prefix))
+	return prefix + hex.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value encrypted under any key in the keyring
+func (k *Keyring) Decrypt(encryptedData string) (string, error) {
+	if len(encryptedData) == 0 {
+		return "", fmt.Errorf("encrypted data is empty")
+	}
+
+	if !strings.HasPrefix(encryptedData, "v") {
+		if k.legacyKey == nil {
+			return "", fmt.Errorf("no legacy key configured")
+		}
+		return decryptLegacyCBC(encryptedData, k.legacyKey)
+	}
+
+	versionStr, payload, ok := strings.Cut(encryptedData[1:], ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	aead, ok := k.keys[version]
+	if !ok {
+		return "", fmt.Errorf("encryption key version %d is not configured", version)
+	}
+
+	sealed, err := hex.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(envelopePrefix(version)))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value")
+	}
+	return string(plaintext), nil
+}
+
+// IsCurrent reports whether a value is encrypted under the active key
+func (k *Keyring) IsCurrent(encryptedData string) bool {
+	return strings.HasPrefix(encryptedData, envelopePrefix(k.activeVersion))
+}
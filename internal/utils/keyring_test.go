@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testKey returns a 32-byte key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// encryptLegacyCBC encrypts a value the way the former AES-CBC scheme did, with a fixed IV
+// and PKCS#7 padding
+func encryptLegacyCBC(t *testing.T, data string, key []byte) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("aes.NewCipher() error = %v", err)
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plaintext := append([]byte(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	iv := bytes.Repeat([]byte{0x42}, aes.BlockSize)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	return hex.EncodeToString(append(iv, ciphertext...))
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name          string
+		keys          map[int][]byte
+		activeVersion int
+		legacyKey     []byte
+		wantErr       bool
+	}{
+		{"single key", map[int][]byte{1: testKey(1)}, 1, nil, false},
+		{"rotated keys with legacy key", map[int][]byte{1: testKey(1), 2: testKey(2)}, 2, testKey(9)[:16], false},
+		{"short key", map[int][]byte{1: testKey(1)[:16]}, 1, nil, true},
+		{"missing active key", map[int][]byte{1: testKey(1)}, 2, nil, true},
+		{"bad legacy key length", map[int][]byte{1: testKey(1)}, 1, testKey(9)[:20], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.keys, tt.activeVersion, tt.legacyKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyringEnvelope(t *testing.T) {
+	old, err := NewKeyring(map[int][]byte{1: testKey(1)}, 1, nil)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	rotated, err := NewKeyring(map[int][]byte{1: testKey(1), 2: testKey(2)}, 2, nil)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	oldValue, err := old.Encrypt("4111111111111111")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	newValue, err := rotated.Encrypt("4111111111111111")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(oldValue, "v1:") || !strings.HasPrefix(newValue, "v2:") {
+		t.Fatalf("Encrypt() = %q and %q, want envelopes of key versions 1 and 2", oldValue, newValue)
+	}
+	again, err := rotated.Encrypt("4111111111111111")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if again == newValue {
+		t.Error("Encrypt() returned the same ciphertext twice, want a fresh nonce")
+	}
+
+	tests := []struct {
+		name        string
+		keyring     *Keyring
+		value       string
+		wantCurrent bool
+	}{
+		{"active key", rotated, newValue, true},
+		{"retired key", rotated, oldValue, false},
+		{"before rotation", old, oldValue, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := tt.keyring.Decrypt(tt.value)
+			if err != nil || plaintext != "4111111111111111" {
+				t.Errorf("Decrypt() = %q, %v, want the original value", plaintext, err)
+			}
+			if got := tt.keyring.IsCurrent(tt.value); got != tt.wantCurrent {
+				t.Errorf("IsCurrent() = %v, want %v", got, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestKeyringDecryptRejectsTampering(t *testing.T) {
+	// Both versions hold the same key, so only the authenticated prefix tells them apart
+	keyring, err := NewKeyring(map[int][]byte{1: testKey(1), 2: testKey(1)}, 1, nil)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	value, err := keyring.Encrypt("12/29")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	payload := strings.TrimPrefix(value, "v1:")
+	flipped := []byte(payload)
+	if flipped[len(flipped)-1] == '0' {
+		flipped[len(flipped)-1] = '1'
+	} else {
+		flipped[len(flipped)-1] = '0'
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"relabelled key version", "v2:" + payload},
+		{"modified ciphertext", "v1:" + string(flipped)},
+		{"truncated ciphertext", "v1:" + payload[:20]},
+		{"unknown key version", "v3:" + payload},
+		{"non-numeric version", "vx:" + payload},
+		{"missing separator", "v1" + payload},
+		{"not hex", "v1:" + strings.Repeat("z", len(payload))},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plaintext, err := keyring.Decrypt(tt.value); err == nil {
+				t.Errorf("Decrypt(%q) = %q, want an error", tt.value, plaintext)
+			}
+		})
+	}
+}
+
+func TestKeyringLegacyFallback(t *testing.T) {
+	legacyKey := testKey(9)
+	keyring, err := NewKeyring(map[int][]byte{1: testKey(1)}, 1, legacyKey)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	withoutLegacy, err := NewKeyring(map[int][]byte{1: testKey(1)}, 1, nil)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		value     string
+		plaintext string
+	}{
+		{"short value", encryptLegacyCBC(t, "123", legacyKey), "123"},
+		{"full block of padding", encryptLegacyCBC(t, "4111111111111111", legacyKey), "4111111111111111"},
+		{"multiple blocks", encryptLegacyCBC(t, "2200000000000004|12/29|Ivan Petrov", legacyKey), "2200000000000004|12/29|Ivan Petrov"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := keyring.Decrypt(tt.value)
+			if err != nil || plaintext != tt.plaintext {
+				t.Errorf("Decrypt() = %q, %v, want %q", plaintext, err, tt.plaintext)
+			}
+			if keyring.IsCurrent(tt.value) {
+				t.Error("IsCurrent() = true for a legacy value")
+			}
+			if _, err := withoutLegacy.Decrypt(tt.value); err == nil {
+				t.Error("Decrypt() succeeded without a legacy key")
+			}
+		})
+	}
+
+	wrongKey := encryptLegacyCBC(t, "4111111111111111", testKey(8))
+	if plaintext, err := keyring.Decrypt(wrongKey); err == nil && plaintext == "4111111111111111" {
+		t.Error("Decrypt() recovered a legacy value encrypted under another key")
+	}
+	for _, value := range []string{"abcd", "zz" + strings.Repeat("0", 62), strings.Repeat("0", 48)} {
+		if _, err := keyring.Decrypt(value); err == nil {
+			t.Errorf("Decrypt(%q) accepted a malformed legacy value", value)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxPasswordBytes is the longest password bcrypt can hash
+const maxPasswordBytes = 72
+
+// PasswordPolicy validates new passwords against a minimum length and a list of breached passwords
+type PasswordPolicy struct {
+	minLength int
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy creates a password policy. breachedFile, if set, names a file with one
+// breached password per line; matching is case-insensitive.
+func NewPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{minLength: minLength, breached: make(map[string]struct{})}
+	if breachedFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.breached[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return p, nil
+}
+
+// BreachedCount returns the number of passwords in the breached list
+func (p *PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
+
+// Check validates a new password for the user with the given username and email
+func (p *PasswordPolicy) Check(password, username, email string) error {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("password must be at least %d characters", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	lower := strings.ToLower(password)
+	if lower == strings.ToLower(username) || lower == strings.ToLower(email) {
+		return fmt.Errorf("password must not match the username or email")
+	}
+	if _, ok := p.breached[lower]; ok {
+		return fmt.Errorf("password appears in a list of breached passwords")
+	}
+	return nil
+}
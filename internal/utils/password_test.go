@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(breachedFile, []byte("Password123\n  qwerty12345  \n\ncorrecthorse\n"), 0o600); err != nil {
+		t.Fatalf("failed to write breached password list: %v", err)
+	}
+	policy, err := NewPasswordPolicy(8, breachedFile)
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() error = %v", err)
+	}
+	if got := policy.BreachedCount(); got != 3 {
+		t.Errorf("BreachedCount() = %d, want 3", got)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{"valid", "long enough secret", ""},
+		{"minimum length", "8chars!!", ""},
+		{"too short", "7chars!", "password must be at least 8 characters"},
+		{"length counted in characters", "пароль12", ""},
+		{"short in characters but not in bytes", "пароль1", "password must be at least 8 characters"},
+		{"maximum bytes", strings.Repeat("a", 72), ""},
+		{"too many bytes", strings.Repeat("a", 73), "password must be at most 72 bytes"},
+		{"multibyte over the byte limit", strings.Repeat("я", 37), "password must be at most 72 bytes"},
+		{"username", "IvanPetrov", "password must not match the username or email"},
+		{"email", "Ivan.Petrov@Example.com", "password must not match the username or email"},
+		{"breached", "password123", "password appears in a list of breached passwords"},
+		{"breached with surrounding spaces in list", "QWERTY12345", "password appears in a list of breached passwords"},
+		{"breached substring is allowed", "correcthorsebattery", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "ivanpetrov", "ivan.petrov@example.com")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Check(%q) error = %v, want nil", tt.password, err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("Check(%q) error = %v, want %q", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewPasswordPolicy(t *testing.T) {
+	policy, err := NewPasswordPolicy(12, "")
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() error = %v", err)
+	}
+	if got := policy.BreachedCount(); got != 0 {
+		t.Errorf("BreachedCount() = %d, want 0 without a breached password list", got)
+	}
+	if err := policy.Check("elevenchars", "user", "user@example.com"); err == nil {
+		t.Error("Check() accepted a password below the configured minimum length")
+	}
+
+	if _, err := NewPasswordPolicy(8, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("NewPasswordPolicy() accepted a missing breached password list")
+	}
+}
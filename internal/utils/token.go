@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GenerateToken generates a random URL-safe token from the given number of random bytes
+func GenerateToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken hashes a high-entropy token for storage and lookup
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSignedToken creates a stateless token for a user of the form
+// "<userID>.<expiry>.<signature>". The signature covers the purpose and binding, such as
+// the email address being verified, so the token is invalidated when the binding changes.
+func NewSignedToken(key, purpose string, userID int64, binding string, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, expiresAt.Unix())
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose + "\x00" + payload + "\x00" + binding))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedTokenUserID extracts the user ID and expiry of a token created by NewSignedToken
+// without verifying it. The token must then be verified with VerifySignedToken.
+func SignedTokenUserID(token string) (int64, time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, time.Time{}, fmt.Errorf("malformed token")
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("malformed token")
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("malformed token")
+	}
+	return userID, time.Unix(expiry, 0), nil
+}
+
+// VerifySignedToken reports whether token is an unexpired token created by NewSignedToken
+// for the purpose, user and binding
+func VerifySignedToken(key, purpose, token string, userID int64, binding string) bool {
+	tokenUserID, expiresAt, err := SignedTokenUserID(token)
+	if err != nil || tokenUserID != userID || !time.Now().Before(expiresAt) {
+		return false
+	}
+	expected := NewSignedToken(key, purpose, userID, binding, expiresAt)
+	return hmac.Equal([]byte(expected), []byte(token))
+}
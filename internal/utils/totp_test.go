@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 test key of RFC 6238, "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC 6238 test vectors have eight digits; six-digit codes are their last six digits
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		if got := totpCode([]byte("12345678901234567890"), tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step, ok := VerifyTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("VerifyTOTP(T=%d) = %d, %v, want %d, true", tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+
+	code := "050471" // T=1111111111, time step 37037037
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     int64
+		wantOK bool
+	}{
+		{"one step early", rfc6238Secret, code, 1111111111 - totpPeriod, true},
+		{"one step late", rfc6238Secret, code, 1111111111 + totpPeriod, true},
+		{"two steps late", rfc6238Secret, code, 1111111111 + 2*totpPeriod, false},
+		{"lowercase secret", strings.ToLower(rfc6238Secret), code, 1111111111, true},
+		{"wrong code", rfc6238Secret, "050472", 1111111111, false},
+		{"short code", rfc6238Secret, "50471", 1111111111, false},
+		{"eight-digit code", rfc6238Secret, "14050471", 1111111111, false},
+		{"invalid secret", "not base32!", code, 1111111111, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := VerifyTOTP(tt.secret, tt.code, time.Unix(tt.at, 0)); ok != tt.wantOK {
+				t.Errorf("VerifyTOTP() = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("GenerateTOTPSecret() = %q, want 32 base32 characters", secret)
+	}
+	now := time.Now()
+	code := totpCode(mustDecodeBase32(t, secret), now.Unix()/totpPeriod)
+	if _, ok := VerifyTOTP(secret, code, now); !ok {
+		t.Errorf("VerifyTOTP() rejected the current code of a generated secret")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ABCDE-FGHIJ", "ABCDEFGHIJ"},
+		{"abcde-fghij", "ABCDEFGHIJ"},
+		{" abcde fghij ", "ABCDEFGHIJ"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+// mustDecodeBase32 decodes an unpadded base32 TOTP secret
+func mustDecodeBase32(t *testing.T, secret string) []byte {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret %q: %v", secret, err)
+	}
+	return key
+}